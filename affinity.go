@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var cpuList = flag.String("cpus", "", "List of CPUs (e.g. 0-3,6) to pin parunner and the local instances to; parunner uses the first one and the instances use the others in a round-robin fashion, or all share it if only one is given (Linux only)")
+
+// parseCPUList parses a list of CPU numbers and ranges of CPU numbers, like 0-3,6.
+func parseCPUList(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		from, to := part, part
+		if i := strings.Index(part, "-"); i != -1 {
+			from, to = part[:i], part[i+1:]
+		}
+		first, err := strconv.Atoi(from)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU list %q", s)
+		}
+		last, err := strconv.Atoi(to)
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid CPU list %q", s)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// instanceCPUs returns the CPUs that the instance with the given ID is pinned to, or nil
+// if it isn't pinned.
+func instanceCPUs(cpus []int, id int) []int {
+	switch len(cpus) {
+	case 0:
+		return nil
+	case 1:
+		return cpus
+	default:
+		return []int{cpus[1+id%(len(cpus)-1)]}
+	}
+}
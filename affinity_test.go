@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	testcases := []struct {
+		s    string
+		want []int
+	}{
+		{"", nil},
+		{"3", []int{3}},
+		{"0-3,6", []int{0, 1, 2, 3, 6}},
+		{"8,1-2", []int{8, 1, 2}},
+	}
+	for _, tc := range testcases {
+		got, err := parseCPUList(tc.s)
+		if err != nil {
+			t.Errorf("parseCPUList(%q): unexpected error: %v", tc.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseCPUList(%q): got=%v, want=%v", tc.s, got, tc.want)
+		}
+	}
+	for _, s := range []string{"a", "1,", "3-1", "-1", "1-"} {
+		if _, err := parseCPUList(s); err == nil {
+			t.Errorf("parseCPUList(%q): expected an error", s)
+		}
+	}
+}
+
+func TestInstanceCPUs(t *testing.T) {
+	if got := instanceCPUs(nil, 3); got != nil {
+		t.Errorf("instanceCPUs without CPUs: got=%v, want=nil", got)
+	}
+	if got := instanceCPUs([]int{5}, 3); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("instanceCPUs with one CPU: got=%v, want=[5]", got)
+	}
+	for id, want := range []int{1, 2, 3, 1, 2} {
+		if got := instanceCPUs([]int{0, 1, 2, 3}, id); !reflect.DeepEqual(got, []int{want}) {
+			t.Errorf("instanceCPUs for instance %d: got=%v, want=[%d]", id, got, want)
+		}
+	}
+}
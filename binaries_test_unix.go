@@ -1,6 +0,0 @@
-// +build darwin dragonfly freebsd linux netbsd openbsd solaris
-
-package main
-
-const testerPath = "zeus/tester"
-const hangerPath = "zeus/hanger"
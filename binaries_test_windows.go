@@ -1,4 +0,0 @@
-package main
-
-const testerPath = "zeus\\tester.exe"
-const hangerPath = "zeus\\hanger.exe"
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/robryk/parunner/runner"
+)
+
+var debugInstance = flag.Int("debug_instance", -1, "ID of an instance to debug; see -debug_mode")
+var debugMode = flag.String("debug_mode", "gdb", "How to debug the instance chosen with -debug_instance: gdb or stop")
+
+const debugUsage = `Debugging:
+  gdb: Run the instance under gdb on the controlling terminal. The instance's standard input is
+    set up in gdb's arguments, so that it is used once the program is run. Its standard output
+    and error go to the terminal. As gdb's own CPU time can't be told apart from the instance's,
+    the instance's CPU time is the one it reports in its last request, like with -wrapper.
+  stop: Stop the instance with SIGSTOP before it executes its binary and print its PID, so that a
+    debugger can attach to it. The binary is executed once the instance is continued.
+  In both cases the time the instance spends stopped in the debugger does not count towards its
+  simulated time.
+`
+
+// debugCommand returns a command that runs binary under gdb on the controlling terminal. The
+// instance's standard input is read from input and stored in a temporary file. The returned
+// function removes that file and gives the terminal back to parunner; it has to be called once
+// the command has finished and may be called more than once.
+func debugCommand(binary string, input io.Reader) (*exec.Cmd, func(), error) {
+	f, err := ioutil.TempFile("", "parunner-input")
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = io.Copy(f, input)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+	tty, err := openTerminal()
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, nil, fmt.Errorf("cannot open the controlling terminal for the debugger: %v", err)
+	}
+	cmd := exec.Command("gdb", "-q", "-ex", "set args < "+shellQuote(f.Name()), binary)
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	restore := setForeground(cmd, tty)
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			restore()
+			tty.Close()
+			os.Remove(f.Name())
+		})
+	}
+	return cmd, cleanup, nil
+}
+
+// stopReporter returns an Observer that tells the user how to attach a debugger to the
+// instance with the given ID, once it is started stopped.
+func stopReporter(id int) runner.Observer {
+	return runner.ObserverFunc(func(e runner.Event) {
+		if e.Type == runner.InstanceStarted && e.Instance == id && e.PID != 0 {
+			fmt.Fprintf(os.Stderr, "Instance %d with PID %d is stopped. Attach a debugger to it (e.g. gdb -p %d) and continue it.\n", id, e.PID, e.PID)
+		}
+	})
+}
+
+// shellQuote quotes s for use in a POSIX shell command line.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
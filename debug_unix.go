@@ -0,0 +1,32 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+func openTerminal() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// setForeground makes cmd run in its own process group, that is placed in the foreground of
+// the terminal. This way, keys like Ctrl-C are delivered to the debugger only. The returned
+// function, to be called once cmd has finished, gives the terminal back to the process group
+// that was in its foreground before.
+func setForeground(cmd *exec.Cmd, tty *os.File) func() {
+	pgrp, err := foregroundGroup(tty)
+	// A process from a background process group that tries to change the foreground one
+	// gets stopped with SIGTTOU unless it ignores that signal.
+	signal.Ignore(syscall.SIGTTOU)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Foreground: true, Ctty: int(tty.Fd())}
+	return func() {
+		if err == nil {
+			setForegroundGroup(tty, pgrp)
+		}
+		signal.Reset(syscall.SIGTTOU)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func openTerminal() (*os.File, error) {
+	return nil, errors.New("debugging under gdb is not supported on Windows")
+}
+
+func setForeground(cmd *exec.Cmd, tty *os.File) func() {
+	return func() {}
+}
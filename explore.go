@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+
+	"github.com/robryk/parunner/runner"
+)
+
+var choices = flag.String("choices", "", "Comma-separated choices of the messages received by the instances' requests to receive from any instance, as printed in explore mode, e.g. 1.0=2 to make the first such request of instance 1 receive the message from instance 2")
+var exploreRuns = flag.Int("explore_runs", 100, "Maximum number of runs in explore mode")
+var exploreStrategy = flag.String("explore_strategy", "dfs", "How explore mode chooses the messages received: dfs or random; see below")
+var exploreSeed = flag.Int64("explore_seed", 1, "Seed for -explore_strategy=random")
+
+// exploreMode is set when parunner is run as "parunner explore".
+var exploreMode bool
+
+const exploreUsage = `Explore mode:
+  When messages from several instances wait for a request to receive from any instance, parunner
+  chooses one of them. Explore mode runs the instances repeatedly, making different choices, and
+  stops at the first run in which the standard output or the exit status of an instance differs
+  from the first run. It then prints the choices made in that run, which can be passed to -choices
+  to run the instances with the same choices again. -explore_strategy=dfs enumerates the possible
+  choices depth-first, starting with the lowest sender; random makes random choices. A choice is
+  identified by the receiver and the index of its request. Replaying the choices fixes which
+  message each of these requests receives, but not the behavior of instances that depends on
+  timing in other ways, e.g. on the time they measure. The instances' outputs are not shown in
+  explore mode.
+`
+
+// nextChoices returns the choices that start the schedule that follows the one in which
+// made were made, in a depth-first enumeration that tries the senders of each choice in
+// increasing order. It returns false if made were the last ones.
+func nextChoices(made []runner.Choice) ([]runner.Choice, bool) {
+	for i := len(made) - 1; i >= 0; i-- {
+		c := made[i]
+		for j, sender := range c.Options {
+			if sender == c.Sender && j+1 < len(c.Options) {
+				next := append([]runner.Choice(nil), made[:i+1]...)
+				next[i].Sender = c.Options[j+1]
+				return next, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// An outcome is what the runs of explore mode are compared by.
+type outcome struct {
+	stdouts  []string
+	verdicts []string
+	deadlock bool
+}
+
+// diff describes the first difference between o and the outcome of the first run, or returns
+// "" if there is none.
+func (o *outcome) diff(first *outcome) string {
+	if o.deadlock != first.deadlock {
+		if o.deadlock {
+			return "the instances have deadlocked"
+		}
+		return "the instances haven't deadlocked"
+	}
+	for i := range o.verdicts {
+		if o.verdicts[i] != first.verdicts[i] {
+			return fmt.Sprintf("the exit status of instance %d is %s instead of %s", i, o.verdicts[i], first.verdicts[i])
+		}
+		if o.stdouts[i] != first.stdouts[i] {
+			return fmt.Sprintf("the stdout of instance %d is %q instead of %q", i, o.stdouts[i], first.stdouts[i])
+		}
+	}
+	return ""
+}
+
+// exploreRun runs the instances with the given chooser and returns the outcome. newCommand
+// and instanceOptions give the command and the options of an instance.
+func exploreRun(ctx context.Context, opts runner.Options, chooser runner.Chooser, newCommand func(i int) (*exec.Cmd, error), instanceOptions func(i int) runner.InstanceOptions) (*outcome, error) {
+	n := *nInstances
+	opts.Commands = make([]*exec.Cmd, n)
+	opts.Instances = make([]runner.InstanceOptions, n)
+	opts.Chooser = chooser
+	stdouts := make([]bytes.Buffer, n)
+	for i := range opts.Commands {
+		var err error
+		if opts.Commands[i], err = newCommand(i); err != nil {
+			return nil, err
+		}
+		opts.Instances[i] = instanceOptions(i)
+		var stdout, stderr runner.OutputSink = runner.NewPassthroughSink(&stdouts[i]), runner.NewPassthroughSink(ioutil.Discard)
+		if *outputLimit > 0 {
+			stdout = &runner.LimitSink{Sink: stdout, Limit: *outputLimit}
+			stderr = &runner.LimitSink{Sink: stderr, Limit: *outputLimit}
+		}
+		opts.Instances[i].Stdout, opts.Instances[i].Stderr = stdout, stderr
+	}
+	instances, err := runner.RunInstances(ctx, opts)
+	o := &outcome{}
+	switch err := err.(type) {
+	case nil, runner.ErrRemainingMessages:
+	case runner.ErrDeadlock:
+		o.deadlock = true
+	case runner.InstanceError:
+		// An instance that couldn't be started has no termination.
+		if err.Termination == nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	for i, instance := range instances {
+		o.stdouts = append(o.stdouts, stdouts[i].String())
+		o.verdicts = append(o.verdicts, instance.Termination.Verdict())
+	}
+	return o, nil
+}
+
+// explore runs the instances in explore mode and returns the exit code. The choices of the
+// first run start with initial.
+func explore(ctx context.Context, opts runner.Options, initial []runner.Choice, newCommand func(i int) (*exec.Cmd, error), instanceOptions func(i int) runner.InstanceOptions) int {
+	var rng *rand.Rand
+	if *exploreStrategy == "random" {
+		rng = rand.New(rand.NewSource(*exploreSeed))
+	}
+	var first *outcome
+	var firstChoices []runner.Choice
+	next := initial
+	for run := 1; run <= *exploreRuns; run++ {
+		schedule := runner.NewSchedule(next)
+		schedule.Rand = rng
+		o, err := exploreRun(ctx, opts, schedule, newCommand, instanceOptions)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		made := schedule.Choices()
+		if first == nil {
+			first, firstChoices = o, made
+		} else if d := o.diff(first); d != "" {
+			fmt.Printf("Run %d differs from the first one: %s\n", run, d)
+			fmt.Printf("Choices of the first run: -choices=%s\n", runner.FormatChoices(firstChoices))
+			fmt.Printf("Choices of run %d: -choices=%s\n", run, runner.FormatChoices(made))
+			return 1
+		}
+		if rng == nil {
+			var ok bool
+			if next, ok = nextChoices(made); !ok {
+				fmt.Printf("Explored all %d runs; the outputs and exit statuses of the instances were the same in all of them\n", run)
+				return 0
+			}
+		}
+	}
+	fmt.Printf("Explored %d runs; the outputs and exit statuses of the instances were the same in all of them\n", *exploreRuns)
+	return 0
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/robryk/parunner/runner"
+)
+
+func TestNextChoices(t *testing.T) {
+	// Instance 0 receives from any of instances 1, 2 and 3 twice, so there are 6 schedules.
+	var schedules []string
+	var made []runner.Choice
+	for {
+		// The first choice is made from all the senders and the second from the remaining two.
+		next := map[int]int{}
+		for _, c := range made {
+			next[c.Index] = c.Sender
+		}
+		first, ok := next[0]
+		if !ok {
+			first = 1
+		}
+		var rest []int
+		for _, id := range []int{1, 2, 3} {
+			if id != first {
+				rest = append(rest, id)
+			}
+		}
+		second, ok := next[1]
+		if !ok {
+			second = rest[0]
+		}
+		made = []runner.Choice{{Receiver: 0, Index: 0, Sender: first, Options: []int{1, 2, 3}}, {Receiver: 0, Index: 1, Sender: second, Options: rest}}
+		schedules = append(schedules, runner.FormatChoices(made))
+		if made, ok = nextChoices(made); !ok {
+			break
+		}
+		if len(schedules) > 10 {
+			t.Fatalf("too many schedules: %v", schedules)
+		}
+	}
+	want := []string{"0.0=1,0.1=2", "0.0=1,0.1=3", "0.0=2,0.1=1", "0.0=2,0.1=3", "0.0=3,0.1=1", "0.0=3,0.1=2"}
+	if len(schedules) != len(want) {
+		t.Fatalf("wrong schedules: got=%q, want=%q", schedules, want)
+	}
+	for i := range want {
+		if schedules[i] != want[i] {
+			t.Errorf("wrong schedule %d: got=%q, want=%q", i, schedules[i], want[i])
+		}
+	}
+}
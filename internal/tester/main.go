@@ -0,0 +1,226 @@
+// Command tester is an instance used by parunner's tests. It speaks the protocol of
+// zeus_local.c and executes a script read from its standard input, one command per line:
+//
+//	Rx      receive a message from instance x (a for 0, b for 1, ...) and print
+//	        "<sender> <length> <message>"
+//	R*      receive a message from any instance and print it as above
+//	Sx<msg> send the rest of the line to instance x
+//	T<ms>   report a CPU time of ms milliseconds in the following requests, instead of
+//	        the measured one; T alone goes back to the measured time
+//	P<cmd>  execute the send or receive cmd, writing its request one byte at a time
+//	E<cmd>  start the send or receive cmd and exit with code 0 in the middle of it: after
+//	        writing half of the send request, or after reading the header of the response
+//	C       use some CPU time
+//	M<n>    allocate n MiB of memory and keep it
+//	H       hang
+//	Q<n>    exit with code n
+//	X       abort
+//
+// At the start it prints "<instance ID> <number of instances>". There is no command to poll
+// for messages, as the protocol has no such operation.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robryk/parunner/wire"
+)
+
+// allocated keeps the memory allocated by the M command.
+var allocated [][]byte
+
+type tester struct {
+	header *wire.Header
+	in     io.Reader
+	out    io.Writer
+	d      *wire.Decoder
+	shm    *sharedMemory
+	// fixedTime, if non-negative, is the CPU time reported in the requests.
+	fixedTime time.Duration
+}
+
+func (t *tester) time() time.Duration {
+	if t.fixedTime >= 0 {
+		return t.fixedTime
+	}
+	return cpuTime()
+}
+
+func peer(c byte) int {
+	if c == '*' {
+		return -1
+	}
+	return int(c - 'a')
+}
+
+// request encodes the request of a send or receive command.
+func (t *tester) request(cmd string) ([]byte, error) {
+	if len(cmd) < 2 {
+		return nil, fmt.Errorf("invalid command %q", cmd)
+	}
+	var req *wire.Request
+	switch cmd[0] {
+	case 'S':
+		req = &wire.Request{Op: wire.OpSend, Peer: peer(cmd[1]), Time: t.time(), Payload: []byte(cmd[2:])}
+		if t.shm != nil && t.shm.put(req.Payload) {
+			req.Op = wire.OpSendShared
+			req.Length = len(req.Payload)
+		}
+	case 'R':
+		req = &wire.Request{Op: wire.OpRecv, Peer: peer(cmd[1]), Time: t.time()}
+	default:
+		return nil, fmt.Errorf("invalid command %q", cmd)
+	}
+	var buf bytes.Buffer
+	if err := wire.NewEncoder(&buf).WriteRequest(req); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *tester) receive() error {
+	resp, err := t.d.ReadResponse()
+	if err != nil {
+		return err
+	}
+	payload := resp.Payload
+	if resp.Shared {
+		if t.shm == nil {
+			return fmt.Errorf("shared response without shared memory")
+		}
+		payload = t.shm.received(resp.Length)
+	}
+	fmt.Printf("%d %d %s\n", resp.Source, len(payload), payload)
+	return nil
+}
+
+func (t *tester) execute(cmd string) error {
+	switch cmd[0] {
+	case 'S', 'R':
+		req, err := t.request(cmd)
+		if err != nil {
+			return err
+		}
+		if _, err := t.out.Write(req); err != nil {
+			return err
+		}
+		if cmd[0] == 'R' {
+			return t.receive()
+		}
+	case 'P':
+		req, err := t.request(cmd[1:])
+		if err != nil {
+			return err
+		}
+		for i := range req {
+			if _, err := t.out.Write(req[i : i+1]); err != nil {
+				return err
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if cmd[1] == 'R' {
+			return t.receive()
+		}
+	case 'E':
+		req, err := t.request(cmd[1:])
+		if err != nil {
+			return err
+		}
+		if cmd[1] == 'S' {
+			req = req[:len(req)/2]
+		}
+		if _, err := t.out.Write(req); err != nil {
+			return err
+		}
+		if cmd[1] == 'R' {
+			var header [12]byte
+			if _, err := io.ReadFull(t.in, header[:]); err != nil {
+				return err
+			}
+		}
+		os.Exit(0)
+	case 'T':
+		if len(cmd) == 1 {
+			t.fixedTime = -1
+			break
+		}
+		ms, err := strconv.Atoi(cmd[1:])
+		if err != nil {
+			return fmt.Errorf("invalid time in %q: %v", cmd, err)
+		}
+		t.fixedTime = time.Duration(ms) * time.Millisecond
+	case 'M':
+		mib, err := strconv.Atoi(cmd[1:])
+		if err != nil {
+			return fmt.Errorf("invalid size in %q: %v", cmd, err)
+		}
+		buf := make([]byte, mib<<20)
+		// The pages only count once they are touched.
+		for i := 0; i < len(buf); i += 4096 {
+			buf[i] = 1
+		}
+		allocated = append(allocated, buf)
+	case 'C':
+		for start := cpuTime(); cpuTime()-start < 20*time.Millisecond; {
+		}
+	case 'H':
+		for {
+			time.Sleep(time.Hour)
+		}
+	case 'Q':
+		code, err := strconv.Atoi(strings.TrimSpace(cmd[1:]))
+		if err != nil {
+			return fmt.Errorf("invalid exit code in %q: %v", cmd, err)
+		}
+		os.Exit(code)
+	case 'X':
+		// With this traceback setting, the runtime aborts the process after the panic.
+		debug.SetTraceback("crash")
+		panic("X command")
+	default:
+		return fmt.Errorf("invalid command %q", cmd)
+	}
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("tester: ")
+	in, out, err := commandFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+	t := &tester{in: in, out: out, d: wire.NewDecoder(in), fixedTime: -1}
+	if t.shm, err = openSharedMemory(); err != nil {
+		log.Fatal(err)
+	}
+	if t.header, err = t.d.ReadHeader(); err != nil {
+		log.Fatalf("reading the header: %v", err)
+	}
+	t.d.NodeLimit = t.header.NodeCount
+	fmt.Printf("%d %d\n", t.header.NodeID, t.header.NodeCount)
+	script := bufio.NewReader(os.Stdin)
+	for {
+		line, err := script.ReadString('\n')
+		if cmd := strings.TrimRight(line, "\r\n"); cmd != "" {
+			if err := t.execute(cmd); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// commandFiles returns the pipes that parunner talks to the instance through.
+func commandFiles() (in *os.File, out *os.File, err error) {
+	return os.NewFile(3, "command input"), os.NewFile(4, "command output"), nil
+}
+
+// cpuTime returns the CPU time used by the process.
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func handleFile(env string) (*os.File, error) {
+	h, err := strconv.ParseUint(os.Getenv(env), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handle in %s: %v", env, err)
+	}
+	return os.NewFile(uintptr(h), env), nil
+}
+
+// commandFiles returns the pipes that parunner talks to the instance through.
+func commandFiles() (in *os.File, out *os.File, err error) {
+	if in, err = handleFile("ZSHANDLE_IN"); err != nil {
+		return nil, nil, err
+	}
+	if out, err = handleFile("ZSHANDLE_OUT"); err != nil {
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration(uint64(ft.HighDateTime)<<32|uint64(ft.LowDateTime)) * 100
+}
+
+// cpuTime returns the CPU time used by the process.
+func cpuTime() time.Duration {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return 0
+	}
+	return filetimeDuration(kernel) + filetimeDuration(user)
+}
@@ -0,0 +1,74 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/robryk/parunner/wire"
+)
+
+// The layout of the shared memory region; it must be kept in sync with parunner's shm.go.
+const (
+	shmHeadOffset  = 0
+	shmTailOffset  = 8
+	shmReadyOffset = 16
+	shmRingOffset  = 64
+	shmRingSize    = wire.MaxMessageSize
+	shmRecvOffset  = shmRingOffset + shmRingSize
+	shmSize        = shmRecvOffset + wire.MaxMessageSize
+)
+
+// sharedMemory is the instance's side of the shared memory region, as in zeus_local.c.
+type sharedMemory struct {
+	mem  []byte
+	head uint64
+}
+
+func (s *sharedMemory) word64(offset int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&s.mem[offset]))
+}
+
+// openSharedMemory maps the region passed by parunner. It returns nil if there is none.
+func openSharedMemory() (*sharedMemory, error) {
+	fdString := os.Getenv("ZEUS_SHM_FD")
+	if fdString == "" {
+		return nil, nil
+	}
+	fd, err := strconv.Atoi(fdString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZEUS_SHM_FD: %v", err)
+	}
+	mem, err := syscall.Mmap(fd, 0, shmSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mapping the shared memory: %v", err)
+	}
+	s := &sharedMemory{mem: mem}
+	s.head = atomic.LoadUint64(s.word64(shmHeadOffset))
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&mem[shmReadyOffset])), 1)
+	return s, nil
+}
+
+// put places payload in the send ring. It returns false if there is not enough space in it.
+func (s *sharedMemory) put(payload []byte) bool {
+	tail := atomic.LoadUint64(s.word64(shmTailOffset))
+	if shmRingSize-(s.head-tail) < uint64(len(payload)) {
+		return false
+	}
+	ring := s.mem[shmRingOffset : shmRingOffset+shmRingSize]
+	n := copy(ring[s.head%shmRingSize:], payload)
+	copy(ring, payload[n:])
+	s.head += uint64(len(payload))
+	atomic.StoreUint64(s.word64(shmHeadOffset), s.head)
+	return true
+}
+
+// received returns the payload of the last response.
+func (s *sharedMemory) received(length int) []byte {
+	return append([]byte(nil), s.mem[shmRecvOffset:shmRecvOffset+length]...)
+}
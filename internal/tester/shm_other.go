@@ -0,0 +1,18 @@
+// +build !linux
+
+package main
+
+type sharedMemory struct{}
+
+// openSharedMemory returns nil, as parunner only passes shared memory regions on Linux.
+func openSharedMemory() (*sharedMemory, error) {
+	return nil, nil
+}
+
+func (s *sharedMemory) put(payload []byte) bool {
+	return false
+}
+
+func (s *sharedMemory) received(length int) []byte {
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/robryk/parunner/runner"
+)
+
+// processGroups is an Observer that keeps track of the process groups of the local instances
+// that are still running, so that they can be killed when parunner is interrupted twice.
+type processGroups struct {
+	mu   sync.Mutex
+	pids map[int]int // PIDs of the instances' processes, by instance ID
+}
+
+func newProcessGroups() *processGroups {
+	return &processGroups{pids: make(map[int]int)}
+}
+
+func (pg *processGroups) Observe(e runner.Event) {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	switch e.Type {
+	case runner.InstanceStarted:
+		if e.PID != 0 {
+			pg.pids[e.Instance] = e.PID
+		}
+	case runner.InstanceFinished:
+		delete(pg.pids, e.Instance)
+	}
+}
+
+// kill kills the process groups of all the instances that are still running.
+func (pg *processGroups) kill() {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	for _, pid := range pg.pids {
+		killProcessGroup(pid)
+	}
+}
@@ -0,0 +1,12 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"syscall"
+)
+
+// killProcessGroup kills the process group led by the process with the given PID.
+func killProcessGroup(pid int) {
+	syscall.Kill(-pid, syscall.SIGKILL)
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+)
+
+// killProcessGroup kills the process with the given PID, as Windows has no process groups.
+func killProcessGroup(pid int) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
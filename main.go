@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -8,26 +9,43 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
-)
 
-const MaxInstances = 100
+	"github.com/robryk/parunner/runner"
+	"github.com/robryk/parunner/wire"
+)
 
-var nInstances = flag.Int("n", 1, fmt.Sprintf("Number of instances; must be from the [1,%d] range", MaxInstances))
-var stdoutHandling = flag.String("stdout", "contest", "Stdout handling: contest, all, tagged, files")
-var stderrHandling = flag.String("stderr", "all", "Stderr handling: all, tagged, files")
+var maxInstances = flag.Int("max_instances", 1000, "Upper limit for -n, as a safeguard against starting a huge number of processes by mistake")
+var nInstances = flag.Int("n", 1, "Number of instances; must be from the [1,max_instances] range")
+var stdoutHandling = flag.String("stdout", "contest", "Stdout handling: contest (contest:node=K, contest:any_one), all, tagged, files, buffered, timeline or none; see below")
+var stderrHandling = flag.String("stderr", "all", "Stderr handling: all, tagged, files, buffered, timeline or none; see below")
 var filesPrefix = flag.String("prefix", "", "Filename prefix for files generated by -stdout=files and -stderr=files")
 var warnRemaining = flag.Bool("warn_unreceived", true, "Warn about messages that remain unreceived after instance's termination")
 var stats = flag.Bool("print_stats", false, "Print per-instance statistics")
 var traceCommunications = flag.Bool("trace_comm", false, "Print out a trace of all messages exchanged")
+var errorTail = flag.Int("error_tail", 10, "Number of last lines of a failing instance's stderr to print with its error; 0 disables")
+var errorTailStdout = flag.Bool("error_tail_stdout", false, "Also print the last lines of a failing instance's stdout with its error")
+var outputLimit = flag.Int64("output_limit", 0, "Limit for the size of each of the output streams of each instance, in bytes; an instance that exceeds it is killed; 0 means no limit")
+var messageCountLimit = flag.Int("message_count_limit", 1000, "Limit for the number of messages sent per instance")
+var messageSizeLimit = flag.Int("message_size_limit", wire.MaxMessageSize, "Limit for the total size of messages sent by an instance, in bytes")
+var memoryLimit = flag.Int64("memory_limit", 0, "Limit for the memory of each instance, in bytes; an instance that exceeds it is killed; 0 means no limit (Linux only, needs a writable cgroup with the memory controller)")
+var timeLimit = flag.Duration("time_limit", 0, "Limit for the simulated time of each instance, e.g. 2s; 0 means no limit")
+var spillThreshold = flag.Int64("spill_threshold", runner.DefaultSpillThreshold, "Total size of unreceived messages kept in memory, in bytes; messages above that are stored in a temporary file")
+var sharedMemory = flag.Bool("shm", false, "Pass message payloads through a shared memory region instead of the communication pipes (Linux only)")
+var sandbox = flag.Bool("sandbox", false, "Run each instance in its own namespaces with a private working directory and a system call filter, which allows threads but not new processes (Linux only)")
+var parallelism = flag.Int("parallelism", 0, "Maximum number of instances that are allowed to compute at the same time; 0 means no limit (local instances only)")
+var repeat = flag.Int("repeat", 1, "Number of times to run the instances; the statistics then show the medians of the times, and only the output of the first run is kept")
 
 var binaryPath string
 
-func writeFile(streamType string, i int, r io.Reader) error {
+// outputFilename returns the name of the file that -stdout=files and -stderr=files
+// store the given stream of instance i in.
+func outputFilename(streamType string, i int) string {
 	binaryDir, binaryFile := filepath.Split(binaryPath)
 	if idx := strings.LastIndex(binaryFile, "."); idx != -1 {
 		binaryFile = binaryFile[:idx]
@@ -36,83 +54,176 @@ func writeFile(streamType string, i int, r io.Reader) error {
 	if *filesPrefix != "" {
 		basename = *filesPrefix
 	}
-	filename := fmt.Sprintf("%s.%s.%d", basename, streamType, i)
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
+	return fmt.Sprintf("%s.%s.%d", basename, streamType, i)
+}
+
+// printTail prints the lines remembered by tb, if there are any.
+func printTail(name string, tb *runner.TailBuffer) {
+	if tb == nil {
+		return
+	}
+	lines := tb.Lines()
+	if len(lines) == 0 {
+		return
 	}
-	_, err = io.Copy(f, r)
-	if err1 := f.Close(); err == nil {
-		err = err1
+	fmt.Fprintf(os.Stderr, "Last lines of %s:\n", name)
+	for _, line := range lines {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
 	}
-	return err
 }
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [flags] binary_to_run\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s explore [flags] binary_to_run\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s worker [flags]\n", os.Args[0])
 	flag.PrintDefaults()
+	fmt.Fprint(os.Stderr, debugUsage)
+	fmt.Fprint(os.Stderr, wrapperUsage)
+	fmt.Fprint(os.Stderr, exploreUsage)
+	fmt.Fprint(os.Stderr, reorderUsage)
 	fmt.Fprintf(os.Stderr, `Output handling modes:
   contest: Fail if more than one instance write any output. Redirect the output to the standard output of this program.
+    contest:node=K only allows instance K to write output. contest:any_one allows any single instance to
+    write output, which is also what plain contest does. Only available for stdout.
   all: Redirect all the instances' outputs to the corresponding output of this program.
   tagged: Redirect all the instances' outputs to the corresponding output of this program, while prefixing each line with instance number.
   files: Store output of each instance in a separate file.
+  buffered: Redirect each instance's output to the corresponding output of this program in one piece, once the instance finishes.
+  none: Discard the output.
+  timeline: Like tagged, but stamp each line with the simulated time of the instance when it was written and
+    print all the lines ordered by these times once the instances finish.
+  Modes can be combined with +, e.g. -stdout=tagged+files. A mode for a single instance can follow the
+  mode for all of them after a comma, e.g. -stdout=none,0=all.
+Remote instances:
+  Instances are run by the workers given in -workers, in a round-robin fashion. The message routing
+  is still done by this program. The binary must be available under the same absolute path on
+  all the workers. They are started with "parunner worker" and only run instances that come with
+  their token, given in -worker_token; see "parunner worker -help" for the trust model.
 `)
 }
 
 func main() {
 	log.SetFlags(log.Lmicroseconds | log.Lshortfile)
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		workerMain(os.Args[2:])
+		return
+	}
 	flag.Usage = Usage
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "explore" {
+		exploreMode = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+	os.Exit(run())
+}
 
+// run runs the instances and returns the exit code. It returns instead of exiting, so that
+// the temporary files are removed.
+func run() int {
 	if flag.NArg() != 1 {
 		fmt.Fprintf(os.Stderr, "Specify the binary name\n")
 		flag.Usage()
-		os.Exit(1)
+		return 1
 	}
 	var err error
 	binaryPath, err = filepath.Abs(flag.Arg(0))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot find absolute path of the binary: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
-	if *nInstances < 1 || *nInstances > MaxInstances {
-		fmt.Fprintf(os.Stderr, "Number of instances should be from [1,%d], but %d was given\n", MaxInstances, *nInstances)
+	if *nInstances < 1 || *nInstances > *maxInstances {
+		fmt.Fprintf(os.Stderr, "Number of instances should be from [1,%d], but %d was given\n", *maxInstances, *nInstances)
 		flag.Usage()
-		os.Exit(1)
+		return 1
 	}
 
-	var writeStdout func(int, io.Reader) error
-	contestStdout := &ContestStdout{Output: os.Stdout}
-	switch *stdoutHandling {
-	case "contest":
-		// This is handled specially (without a pipe) below.
-	case "all":
-	case "tagged":
-		writeStdout = func(i int, r io.Reader) error { return TagStream(fmt.Sprintf("STDOUT %d: ", i), os.Stdout, r) }
-	case "files":
-		writeStdout = func(i int, r io.Reader) error { return writeFile("stdout", i, r) }
-	default:
-		fmt.Fprintf(os.Stderr, "Invalid stdout handling mode: %s", *stdoutHandling)
+	if *debugInstance >= *nInstances || (*debugMode != "gdb" && *debugMode != "stop") {
+		fmt.Fprintf(os.Stderr, "Invalid debugging options: -debug_instance=%d -debug_mode=%s\n", *debugInstance, *debugMode)
 		flag.Usage()
-		os.Exit(1)
+		return 1
 	}
-	var writeStderr func(int, io.Reader) error
-	switch *stderrHandling {
-	case "all":
-	case "tagged":
-		writeStderr = func(i int, r io.Reader) error { return TagStream(fmt.Sprintf("STDERR %d: ", i), os.Stderr, r) }
-	case "files":
-		writeStdout = func(i int, r io.Reader) error { return writeFile("stderr", i, r) }
-	default:
-		fmt.Fprintf(os.Stderr, "Inalid stderr handling mode: %s", *stdoutHandling)
+
+	if *wrapperFor >= *nInstances {
+		fmt.Fprintf(os.Stderr, "Invalid wrapper options: -wrapper_for=%d\n", *wrapperFor)
 		flag.Usage()
-		os.Exit(1)
+		return 1
+	}
+	if *wrapper != "" && *sandbox {
+		// The sandbox's system call filter would not let most wrappers work.
+		fmt.Fprintf(os.Stderr, "-wrapper can't be used together with -sandbox\n")
+		return 1
+	}
+
+	if !(*timeScale > 0) {
+		fmt.Fprintf(os.Stderr, "Invalid time scale: -time_scale=%v\n", *timeScale)
+		return 1
+	}
+	if *repeat < 1 || (*repeat > 1 && *debugInstance != -1) {
+		fmt.Fprintf(os.Stderr, "Invalid number of runs: -repeat=%d\n", *repeat)
+		flag.Usage()
+		return 1
+	}
+
+	if exploreMode && (*debugInstance != -1 || *repeat != 1 || (*exploreStrategy != "dfs" && *exploreStrategy != "random") || *exploreRuns < 1) {
+		fmt.Fprintf(os.Stderr, "Invalid explore mode options: -explore_strategy=%s -explore_runs=%d; -debug_instance and -repeat can't be used in explore mode\n", *exploreStrategy, *exploreRuns)
+		flag.Usage()
+		return 1
+	}
+	forcedChoices, err := runner.ParseChoices(*choices)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	adversary, err := reorderAdversary()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		return 1
+	}
+
+	cpus, err := parseCPUList(*cpuList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if cpus != nil {
+		// Instances are pinned when they're started, but the router runs in this process.
+		if err := runner.PinProcess(cpus[:1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot pin parunner to CPU %d: %v\n", cpus[0], err)
+			return 1
+		}
 	}
 
-	stdinPipe, err := NewFilePipe()
+	stdoutStream, err := newOutputStream("stdout", *stdoutHandling, *nInstances, os.Stdout, func(i int) string { return outputFilename("stdout", i) })
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		return 1
+	}
+	stderrStream, err := newOutputStream("stderr", *stderrHandling, *nInstances, os.Stderr, func(i int) string { return outputFilename("stderr", i) })
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		return 1
+	}
+
+	addrs, token, err := workerAddrs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	speedFactors, err := parseSpeeds(*speeds, *nInstances)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	stdinPipe, err := runner.NewFilePipe()
+	if err != nil {
+		log.Print(err)
+		return 1
 	}
 	defer stdinPipe.Release()
 	go func() {
@@ -125,96 +236,269 @@ func main() {
 			log.Fatal(err)
 		}
 	}()
-	progs := make([]*exec.Cmd, *nInstances)
-	var wg sync.WaitGroup
-	closeAfterWait := []io.Closer{}
-	for i := range progs {
-		cmd := exec.Command(binaryPath)
-		w, err := cmd.StdinPipe()
-		if err != nil {
-			log.Fatal(err)
+	// The instances run in their own process groups, so they don't receive the signals sent
+	// to parunner from the terminal. Instead, we kill them on the first interrupt. Another
+	// interrupt, e.g. if killing them gets stuck, kills their process groups directly and
+	// terminates parunner immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	groups := newProcessGroups()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		<-signals
+		groups.kill()
+		os.Exit(1)
+	}()
+	instanceOptions := func(i int) runner.InstanceOptions {
+		o := runner.InstanceOptions{Speed: speedFactors[i]}
+		if addrs == nil {
+			o.CPUs = instanceCPUs(cpus, i)
+		}
+		if i == *debugInstance {
+			o.Debugged = true
+			o.StopAtStart = *debugMode == "stop"
+			o.TimeFromRequests = *debugMode == "gdb"
+		}
+		if wrapped(i) {
+			// The CPU time measured by the OS would include the wrapper's processes.
+			o.TimeFromRequests = true
+		}
+		o.DetectToolReports = wrapped(i) || *sanitizers
+		return o
+	}
+	opts := runner.Options{
+		MessageCountLimit: *messageCountLimit,
+		MessageSizeLimit:  *messageSizeLimit,
+		TimeLimit:         *timeLimit,
+		MemoryLimit:       *memoryLimit,
+		SpillThreshold:    *spillThreshold,
+		SharedMemory:      *sharedMemory,
+		Sandbox:           *sandbox,
+		Parallelism:       *parallelism,
+		Workers:           addrs,
+		WorkerToken:       token,
+		TimeScale:         *timeScale,
+		Adversary:         adversary,
+	}
+	observers := []runner.Observer{groups}
+	if *traceCommunications {
+		observers = append(observers, runner.NewCommLog(os.Stderr))
+	}
+	if *debugInstance != -1 && *debugMode == "stop" {
+		observers = append(observers, stopReporter(*debugInstance))
+	}
+	opts.Observer = runner.ObserverFunc(func(e runner.Event) {
+		for _, o := range observers {
+			o.Observe(e)
 		}
-		go func() {
-			// We don't care about errors from the writer (we expect broken pipe if the process has exited
-			// before reading all of its input), but we do care about errors when reading from the filepipe.
-			if _, err := io.Copy(WrapWriter(w), stdinPipe.Reader()); err != nil {
-				if _, ok := err.(WriterError); !ok {
-					log.Fatal(err)
+	})
+	if exploreMode {
+		newCommand := func(i int) (*exec.Cmd, error) {
+			return instanceCommand(i, stdinPipe)
+		}
+		return explore(ctx, opts, forcedChoices, newCommand, instanceOptions)
+	}
+	if forcedChoices != nil {
+		opts.Chooser = runner.NewSchedule(forcedChoices)
+	}
+	// The tails of the instances' outputs, shown when an instance fails.
+	var stdoutTails, stderrTails []*runner.TailBuffer
+	// runs are the instances of the runs that have finished successfully.
+	var runs [][]*runner.Instance
+	var instances []*runner.Instance
+	outputFailed := false
+	for run := 0; run < *repeat; run++ {
+		progs := make([]*exec.Cmd, *nInstances)
+		instanceOpts := make([]runner.InstanceOptions, *nInstances)
+		stdoutTails = make([]*runner.TailBuffer, *nInstances)
+		stderrTails = make([]*runner.TailBuffer, *nInstances)
+		var debugCleanup func()
+		for i := range progs {
+			instanceOpts[i] = instanceOptions(i)
+			if i == *debugInstance && *debugMode == "gdb" {
+				cmd, cleanup, err := debugCommand(binaryPath, stdinPipe.Reader())
+				if err != nil {
+					log.Print(err)
+					return 1
 				}
+				defer cleanup()
+				debugCleanup = cleanup
+				progs[i] = cmd
+				continue
 			}
-			w.Close()
-		}()
-		makeFromWrite := func(writeProc func(int, io.Reader) error, w io.Writer) io.Writer {
-			if writeProc == nil {
-				return w
+			cmd, err := instanceCommand(i, stdinPipe)
+			if err != nil {
+				log.Print(err)
+				return 1
 			}
-			pr, pw := io.Pipe()
-			closeAfterWait = append(closeAfterWait, pw)
-			i := i
-			wg.Add(1)
-			go func() {
-				err := writeProc(i, pr)
-				if err != nil {
-					// All the errors we can get are not caused by instances' invalid behaviour, but
-					// by system issues (can't create a file, broken pipe on real stdout/err, etc.)
-					log.Fatal(err)
+			// Only the output of the first run is kept.
+			stdout, stderr := runner.NewPassthroughSink(ioutil.Discard), runner.NewPassthroughSink(ioutil.Discard)
+			if run == 0 {
+				if stdout, err = stdoutStream.Sink(i); err != nil {
+					log.Print(err)
+					return 1
 				}
-				wg.Done()
-			}()
-			return pw
+				if stderr, err = stderrStream.Sink(i); err != nil {
+					stdout.Close()
+					log.Print(err)
+					return 1
+				}
+			}
+			if *outputLimit > 0 {
+				stdout = &runner.LimitSink{Sink: stdout, Limit: *outputLimit}
+				stderr = &runner.LimitSink{Sink: stderr, Limit: *outputLimit}
+			}
+			if *errorTail > 0 {
+				// The sinks are teed rather than wrapped in a MultiWriter, so that the instance
+				// can give them its clock.
+				stderrTails[i] = runner.NewTailBuffer(*errorTail)
+				stderr = runner.NewTeeSink(runner.NewPassthroughSink(stderrTails[i]), stderr)
+				if *errorTailStdout {
+					stdoutTails[i] = runner.NewTailBuffer(*errorTail)
+					stdout = runner.NewTeeSink(runner.NewPassthroughSink(stdoutTails[i]), stdout)
+				}
+			}
+			instanceOpts[i].Stdout, instanceOpts[i].Stderr = stdout, stderr
+			progs[i] = cmd
 		}
-		if *stdoutHandling == "contest" {
-			cmd.Stdout = contestStdout.NewWriter(i)
-		} else {
-			cmd.Stdout = makeFromWrite(writeStdout, os.Stdout)
+		opts.Commands, opts.Instances = progs, instanceOpts
+		// The sinks are closed by RunInstances. Errors from closing them are not caused by
+		// instances' invalid behaviour, but by system issues (can't write a file, broken pipe
+		// on real stdout/err, etc.)
+		instances, err = runner.RunInstances(ctx, opts)
+		// The debugger has finished, so parunner can have the terminal back.
+		if debugCleanup != nil {
+			debugCleanup()
 		}
-		cmd.Stderr = makeFromWrite(writeStderr, os.Stderr)
-		progs[i] = cmd
-	}
-	commLog := ioutil.Discard
-	if *traceCommunications {
-		commLog = os.Stderr
-	}
-	instances, err := RunInstances(progs, commLog)
-	for _, f := range closeAfterWait {
-		f.Close()
-	}
-	wg.Wait()
-	if er, ok := err.(ErrRemainingMessages); ok {
-		if *warnRemaining {
-			m := make(map[int][]int)
-			for _, p := range er.RemainingMessages {
-				m[p.To] = append(m[p.To], p.From)
+		if run == 0 {
+			for _, stream := range []*outputStream{stdoutStream, stderrStream} {
+				if err := stream.Flush(); err != nil {
+					log.Print(err)
+					outputFailed = true
+				}
 			}
-			fmt.Fprintf(os.Stderr, "Warning: following instances had some messages left after they've terminated:\n")
-			for dest, srcs := range m {
-				fmt.Fprintf(os.Stderr, "Instance %d did not receive message from instances: ", dest)
-				for _, src := range srcs {
-					fmt.Fprintf(os.Stderr, "%d ", src)
+		}
+		if er, ok := err.(runner.ErrRemainingMessages); ok {
+			if *warnRemaining && run == 0 {
+				m := make(map[int][]int)
+				for _, p := range er.RemainingMessages {
+					m[p.To] = append(m[p.To], p.From)
+				}
+				fmt.Fprintf(os.Stderr, "Warning: following instances had some messages left after they've terminated:\n")
+				for dest, srcs := range m {
+					fmt.Fprintf(os.Stderr, "Instance %d did not receive message from instances: ", dest)
+					for _, src := range srcs {
+						fmt.Fprintf(os.Stderr, "%d ", src)
+					}
+					fmt.Fprintln(os.Stderr)
 				}
-				fmt.Fprintln(os.Stderr)
 			}
+			err = nil
 		}
-		err = nil
+		if err != nil {
+			break
+		}
+		runs = append(runs, instances)
+	}
+	status := 0
+	if outputFailed {
+		status = 1
 	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		if ie, ok := err.(runner.InstanceError); ok {
+			printTail(fmt.Sprintf("stdout of instance %d", ie.ID), stdoutTails[ie.ID])
+			printTail(fmt.Sprintf("stderr of instance %d", ie.ID), stderrTails[ie.ID])
+		}
+		// The statistics of a failed or interrupted run are still useful.
+		if instances == nil {
+			return 1
+		}
+		runs = [][]*runner.Instance{instances}
+		status = 1
+	}
+	printStats(runs)
+	return status
+}
+
+// instanceCommand returns the command that runs instance i, with the input of parunner,
+// read from stdinPipe, as its standard input.
+func instanceCommand(i int, stdinPipe *runner.FilePipe) (*exec.Cmd, error) {
+	cmd := exec.Command(binaryPath)
+	if wrapped(i) {
+		cmd = wrapperCommand(binaryPath)
+	}
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		// We don't care about errors from the writer (we expect broken pipe if the process has exited
+		// before reading all of its input), but we do care about errors when reading from the filepipe.
+		if _, err := io.Copy(runner.WrapWriter(w), stdinPipe.Reader()); err != nil {
+			if _, ok := err.(runner.WriterError); !ok {
+				log.Fatal(err)
+			}
+		}
+		w.Close()
+	}()
+	return cmd, nil
+}
+
+// printStats prints the duration of the runs and, with -print_stats, the statistics of
+// the instances. If there were several runs, the medians of the times are printed together
+// with their standard deviations.
+func printStats(runs [][]*runner.Instance) {
+	nInstances := len(runs[0])
+	// totalTimes[i], runningTimes[i] and blockedTimes[i] are the times of instance i in the
+	// consecutive runs.
+	totalTimes := make([][]time.Duration, nInstances)
+	runningTimes := make([][]time.Duration, nInstances)
+	blockedTimes := make([][]time.Duration, nInstances)
+	var durations []time.Duration
+	for _, instances := range runs {
+		var maxTime time.Duration
+		for i, instance := range instances {
+			instanceTime := instance.TimeRunning + instance.TimeBlocked
+			if instanceTime > maxTime {
+				maxTime = instanceTime
+			}
+			totalTimes[i] = append(totalTimes[i], instanceTime)
+			runningTimes[i] = append(runningTimes[i], instance.TimeRunning)
+			blockedTimes[i] = append(blockedTimes[i], instance.TimeBlocked)
+		}
+		durations = append(durations, maxTime)
 	}
 	var maxTime time.Duration
 	var lastInstance int
-	for i, instance := range instances {
-		if instanceTime := instance.TimeRunning + instance.TimeBlocked; instanceTime >= maxTime {
+	for i := range totalTimes {
+		if instanceTime := median(totalTimes[i]); instanceTime >= maxTime {
 			maxTime = instanceTime
 			lastInstance = i
 		}
 	}
-	fmt.Fprintf(os.Stderr, "Duration: %v (longest running instance: %d)\n", maxTime, lastInstance)
+	if len(runs) == 1 {
+		fmt.Fprintf(os.Stderr, "Duration: %v (longest running instance: %d)\n", maxTime, lastInstance)
+	} else {
+		fmt.Fprintf(os.Stderr, "Duration: %v, standard deviation %v (median of %d runs; longest running instance: %d)\n", median(durations), stddev(durations), len(runs), lastInstance)
+	}
 	if *stats {
 		w := tabwriter.NewWriter(os.Stderr, 2, 1, 1, ' ', 0)
-		io.WriteString(w, "Instance\tTotal time\tCPU time\tTime spent waiting\tSent messages\tSent bytes\n")
-		for i, instance := range instances {
-			fmt.Fprintf(w, "%d\t%v\t%v\t%v\t%d\t%d\n", i, instance.TimeRunning+instance.TimeBlocked, instance.TimeRunning, instance.TimeBlocked, instance.MessagesSent, instance.MessageBytesSent)
+		if len(runs) == 1 {
+			io.WriteString(w, "Instance\tTotal time\tCPU time\tTime spent waiting\tSent messages\tSent bytes\tTermination\n")
+		} else {
+			io.WriteString(w, "Instance\tTotal time\tTotal time stddev\tCPU time\tTime spent waiting\tSent messages\tSent bytes\tTermination\n")
+		}
+		// The other statistics don't depend on timing, so we take them from the last run.
+		for i, instance := range runs[len(runs)-1] {
+			fmt.Fprintf(w, "%d\t%v\t", i, median(totalTimes[i]))
+			if len(runs) > 1 {
+				fmt.Fprintf(w, "%v\t", stddev(totalTimes[i]))
+			}
+			fmt.Fprintf(w, "%v\t%v\t%d\t%d\t%v\n", median(runningTimes[i]), median(blockedTimes[i]), instance.MessagesSent, instance.MessageBytesSent, &instance.Termination)
 		}
 		w.Flush()
 	}
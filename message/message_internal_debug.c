@@ -66,10 +66,28 @@ static void PutRawByte(Buffer* buffer, unsigned char byte) {
 	buffer->buffer[buffer->pos++] = byte;
 }
 
-#define MAX_MACHINES 100
+// The buffers are allocated on first use, as the number of machines is only known at runtime.
+static Buffer* incoming_buffers;
+static Buffer* outgoing_buffers;
 
-static Buffer incoming_buffers[MAX_MACHINES];
-static Buffer outgoing_buffers[MAX_MACHINES];
+static void AllocateBuffers() {
+	if (incoming_buffers)
+		return;
+	int n = NumberOfNodes();
+	incoming_buffers = (Buffer*)calloc(n, sizeof(Buffer));
+	outgoing_buffers = (Buffer*)calloc(n, sizeof(Buffer));
+	assert(incoming_buffers && outgoing_buffers);
+}
+
+static Buffer* Incoming(int node) {
+	AllocateBuffers();
+	return &incoming_buffers[node];
+}
+
+static Buffer* Outgoing(int node) {
+	AllocateBuffers();
+	return &outgoing_buffers[node];
+}
 
 char recv_buffer[MAX_MESSAGE_SIZE];
 
@@ -79,12 +97,12 @@ int Receive(int source) {
 	if (DEBUG && source == -1) {
 		int i;
 		for(i=0;i<ZEUS(NumberOfNodes)();i++) {
-			if (!Empty(&incoming_buffers[i]))
+			if (!Empty(Incoming(i)))
 				Die("Receive(-1) z nieprzeczytana wiadomoscia");
 		}
 	}
 	ZEUS(MessageInfo) mi = ZEUS(Receive)(source, recv_buffer, sizeof(recv_buffer));
-	Buffer* buf = &incoming_buffers[mi.sender_id];
+	Buffer* buf = Incoming(mi.sender_id);
 	if (!Empty(buf))
 		Die("Receive() odebral wiadomosc od maszyny z nieprzeczytana wiadomoscia");
 	assert(buf->buffer == NULL);
@@ -103,7 +121,7 @@ int Receive(int source) {
 static void GetTag(int source, int expected) {
 	if (!DEBUG)
 		return;
-	int tag = GetRawByte(&incoming_buffers[source]);
+	int tag = GetRawByte(Incoming(source));
 	if (tag != expected)
 		Die("Przeczytano inny typ wartosci niz wyslano");
 }
@@ -113,17 +131,17 @@ int GetInt(int source) {
 	GetTag(source, kInt);
 	int result = 0, i;
 	for(i=0;i<sizeof(int);i++)
-		result |= (int)(GetRawByte(&incoming_buffers[source])) << (8 * i);
+		result |= (int)(GetRawByte(Incoming(source))) << (8 * i);
 	return result;
 }
 
 void PutInt(int target, int value) {
 	CheckNodeId(target);
 	if (DEBUG)
-		PutRawByte(&outgoing_buffers[target], kInt);
+		PutRawByte(Outgoing(target), kInt);
 	int i;
 	for(i=0;i<sizeof(int);i++)
-		PutRawByte(&outgoing_buffers[target], (0xff & (value >> (8 * i))));
+		PutRawByte(Outgoing(target), (0xff & (value >> (8 * i))));
 }
 
 long long GetLL(int source) {
@@ -132,35 +150,35 @@ long long GetLL(int source) {
 	long long result = 0;
 	int i;
 	for(i=0;i<sizeof(long long);i++)
-		result |= (long long)(GetRawByte(&incoming_buffers[source])) << (8 * i);
+		result |= (long long)(GetRawByte(Incoming(source))) << (8 * i);
 	return result;
 }
 
 void PutLL(int target, long long value) {
 	CheckNodeId(target);
 	if (DEBUG)
-		PutRawByte(&outgoing_buffers[target], kLL);
+		PutRawByte(Outgoing(target), kLL);
 	int i;
 	for(i=0;i<sizeof(long long);i++)
-		PutRawByte(&outgoing_buffers[target], (0xff & (value >> (8 * i))));
+		PutRawByte(Outgoing(target), (0xff & (value >> (8 * i))));
 }
 
 char GetChar(int source) {
 	CheckNodeId(source);
 	GetTag(source, kChar);
-	return GetRawByte(&incoming_buffers[source]);
+	return GetRawByte(Incoming(source));
 }
 
 void PutChar(int target, char value) {
 	CheckNodeId(target);
 	if (DEBUG)
-		PutRawByte(&outgoing_buffers[target], kChar);
-	PutRawByte(&outgoing_buffers[target], value);
+		PutRawByte(Outgoing(target), kChar);
+	PutRawByte(Outgoing(target), value);
 }
 
 void Send(int target) {
 	CheckNodeId(target);
-	Buffer* buffer = &outgoing_buffers[target];
+	Buffer* buffer = Outgoing(target);
 	if (buffer->pos > sizeof(recv_buffer))
 		Die("Za dluga wiadomosc");
 	ZEUS(Send)(target, buffer->buffer, buffer->pos);
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/robryk/parunner/runner"
+)
+
+// outputModes are the names of the sinks that can be used in -stdout and -stderr.
+var outputModes = map[string]bool{
+	"contest":  true,
+	"all":      true,
+	"tagged":   true,
+	"files":    true,
+	"buffered": true,
+	"none":     true,
+	"timeline": true,
+}
+
+// An outputStream creates the sinks for one of the output streams of all the instances,
+// according to its -stdout or -stderr flag.
+type outputStream struct {
+	// name is either stdout or stderr.
+	name string
+	// output is parunner's own stream.
+	output io.Writer
+	// filename returns the name of the file for the stream of the given instance.
+	filename func(id int) string
+	// n is the number of instances.
+	n        int
+	contest  *runner.ContestStdout
+	timeline *runner.Timeline
+	// contestOption is the option given to the contest mode, e.g. node=0, if any.
+	contestOption string
+
+	defaultModes []string
+	modes        map[int][]string
+}
+
+// newOutputStream parses the handling of an output stream. The handling is a
+// comma-separated list of modes, where each mode is a plus-separated list of sink names,
+// e.g. tagged+files. Modes other than the first one apply to a single instance and are
+// prefixed with its ID, e.g. tagged,3=all. The contest mode can only be used for stdout.
+func newOutputStream(name string, handling string, n int, output io.Writer, filename func(id int) string) (*outputStream, error) {
+	s := &outputStream{
+		name:     name,
+		output:   output,
+		n:        n,
+		filename: filename,
+		contest:  &runner.ContestStdout{Output: output},
+		timeline: &runner.Timeline{Output: output},
+		modes:    make(map[int][]string),
+	}
+	for i, part := range strings.Split(handling, ",") {
+		id := -1
+		// The options of the modes can contain = too, e.g. contest:node=0.
+		if j := strings.Index(part, "="); j != -1 && !strings.Contains(part[:j], ":") {
+			var err error
+			if id, err = strconv.Atoi(part[:j]); err != nil || id < 0 || id >= n {
+				return nil, fmt.Errorf("invalid instance ID in %s handling mode: %s", name, part)
+			}
+			part = part[j+1:]
+		} else if i > 0 {
+			return nil, fmt.Errorf("%s handling mode for a single instance should be prefixed with its ID: %s", name, part)
+		}
+		modes := strings.Split(part, "+")
+		for j, mode := range modes {
+			if k := strings.Index(mode, ":"); k != -1 && mode[:k] == "contest" {
+				if err := s.setContestOption(mode[k+1:]); err != nil {
+					return nil, err
+				}
+				modes[j] = "contest"
+			} else if !outputModes[mode] {
+				return nil, fmt.Errorf("invalid %s handling mode: %s", name, mode)
+			}
+			// Only the instances' stdouts are checked by the contest systems.
+			if modes[j] == "contest" && name != "stdout" {
+				return nil, fmt.Errorf("the contest mode can't be used for %s", name)
+			}
+		}
+		if id == -1 {
+			s.defaultModes = modes
+		} else {
+			s.modes[id] = modes
+		}
+	}
+	return s, nil
+}
+
+// setContestOption sets up the contest mode according to the option given after its name.
+// All the instances share the contest mode, so all the options given must be the same.
+func (s *outputStream) setContestOption(option string) error {
+	if s.contestOption != "" && s.contestOption != option {
+		return fmt.Errorf("conflicting %s contest modes: %s and %s", s.name, s.contestOption, option)
+	}
+	s.contestOption = option
+	switch {
+	case option == "any_one":
+		// Any single instance may write, which is what the contest mode does by default.
+	case strings.HasPrefix(option, "node="):
+		node, err := strconv.Atoi(strings.TrimPrefix(option, "node="))
+		if err != nil || node < 0 || node >= s.n {
+			return fmt.Errorf("invalid instance ID in %s contest mode: %s", s.name, option)
+		}
+		s.contest.SingleNode, s.contest.Node = true, node
+	default:
+		return fmt.Errorf("invalid %s contest mode: %s", s.name, option)
+	}
+	return nil
+}
+
+// Sink creates the sink for the stream of the instance with the given ID.
+func (s *outputStream) Sink(id int) (runner.OutputSink, error) {
+	modes, ok := s.modes[id]
+	if !ok {
+		modes = s.defaultModes
+	}
+	var sinks []runner.OutputSink
+	for _, mode := range modes {
+		var sink runner.OutputSink
+		switch mode {
+		case "contest":
+			sink = s.contest.NewWriter(id)
+		case "all":
+			sink = runner.NewPassthroughSink(s.output)
+		case "tagged":
+			sink = runner.NewTagSink(fmt.Sprintf("%s %d: ", strings.ToUpper(s.name), id), s.output)
+		case "files":
+			var err error
+			if sink, err = runner.NewFileSink(s.filename(id)); err != nil {
+				runner.NewTeeSink(sinks...).Close()
+				return nil, err
+			}
+		case "buffered":
+			sink = &runner.BufferSink{Output: s.output}
+		case "none":
+			sink = runner.NewPassthroughSink(ioutil.Discard)
+		case "timeline":
+			sink = s.timeline.NewSink(id, fmt.Sprintf("%s %d: ", strings.ToUpper(s.name), id))
+		}
+		sinks = append(sinks, sink)
+	}
+	return runner.NewTeeSink(sinks...), nil
+}
+
+// Flush writes the output that the sinks have kept until all the instances finish. It must
+// be called after all the sinks are closed.
+func (s *outputStream) Flush() error {
+	return s.timeline.Flush()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/robryk/parunner/runner"
+)
+
+// writeAll writes each of writes to sink and closes it.
+func writeAll(t *testing.T, sink runner.OutputSink, writes ...string) {
+	for _, w := range writes {
+		if _, err := sink.Write([]byte(w)); err != nil {
+			t.Errorf("unexpected error when writing %q: %v", w, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("unexpected error when closing the sink: %v", err)
+	}
+}
+
+func TestOutputStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parunner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := func(i int) string { return filepath.Join(dir, fmt.Sprintf("stderr.%d", i)) }
+	var buf bytes.Buffer
+	s, err := newOutputStream("stderr", "tagged+files,1=none,2=all", 4, &buf, filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		sink, err := s.Sink(i)
+		if err != nil {
+			t.Fatalf("cannot create a sink for instance %d: %v", i, err)
+		}
+		writeAll(t, sink, fmt.Sprintf("%c\n", 'a'+i))
+	}
+	if got, want := buf.String(), "STDERR 0: a\nc\nSTDERR 3: d\n"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+	for _, i := range []int{0, 3} {
+		got, err := ioutil.ReadFile(filename(i))
+		if err != nil {
+			t.Errorf("no file for instance %d: %v", i, err)
+			continue
+		}
+		if want := fmt.Sprintf("%c\n", 'a'+i); string(got) != want {
+			t.Errorf("wrong file contents for instance %d: got=%q, want=%q", i, got, want)
+		}
+	}
+	for _, i := range []int{1, 2} {
+		if _, err := os.Stat(filename(i)); err == nil {
+			t.Errorf("unexpected file for instance %d", i)
+		}
+	}
+
+	s, err = newOutputStream("stdout", "none,3=contest:node=3+all", 4, &buf, filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !s.contest.SingleNode || s.contest.Node != 3 || fmt.Sprint(s.modes[3]) != "[contest all]" {
+		t.Errorf("wrong contest mode: %+v, modes of instance 3: %v", s.contest, s.modes[3])
+	}
+
+	s, err = newOutputStream("stdout", "contest:any_one,2=contest:any_one+tagged", 4, &buf, filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.contest.SingleNode || fmt.Sprint(s.modes[2]) != "[contest tagged]" {
+		t.Errorf("wrong contest mode: %+v, modes of instance 2: %v", s.contest, s.modes[2])
+	}
+	// Any instance may be the one that writes, but only one of them.
+	buf.Reset()
+	for _, id := range []int{1, 0} {
+		sink, err := s.Sink(id)
+		if err != nil {
+			t.Fatalf("cannot create a sink for instance %d: %v", id, err)
+		}
+		_, err = sink.Write([]byte("x\n"))
+		if id == 1 && err != nil {
+			t.Errorf("unexpected error from the first writer: %v", err)
+		}
+		if id == 0 && err == nil {
+			t.Errorf("expected an error from the second writer")
+		}
+	}
+	if got, want := buf.String(), "x\n"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+
+	for _, handling := range []string{"", "foo", "all+foo", "all,tagged", "all,x=none", "all,-1=none", "all,4=none", "contest:foo", "contest:node=x", "contest:node=4", "contest:node=1,2=contest:node=2", "contest:any_one,2=contest:node=2", "all:node=1"} {
+		if _, err := newOutputStream("stdout", handling, 4, &buf, filename); err == nil {
+			t.Errorf("newOutputStream(%q): expected an error", handling)
+		}
+	}
+	for _, handling := range []string{"contest", "all,1=tagged+contest:node=1"} {
+		if _, err := newOutputStream("stderr", handling, 4, &buf, filename); err == nil {
+			t.Errorf("newOutputStream(%q) for stderr: expected an error", handling)
+		}
+	}
+}
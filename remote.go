@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/robryk/parunner/runner"
+)
+
+var workers = flag.String("workers", "", "Comma-separated addresses of parunner workers to run the instances on, or loopback:N to start N workers on 127.0.0.1")
+var workerToken = flag.String("worker_token", "", "Secret token required by the workers given in -workers; defaults to $"+tokenEnv)
+
+// tokenEnv is the environment variable that holds the worker token if it isn't given in a
+// flag, which keeps it out of the process list.
+const tokenEnv = "PARUNNER_WORKER_TOKEN"
+
+const workerUsage = `Workers:
+  A worker runs any command that it is sent, as the user it runs as, for anyone who can connect
+  to it and knows its token (-token, or $PARUNNER_WORKER_TOKEN). Without a token it only listens
+  on a loopback address, where it can still be used by all the users of its machine. The token
+  and all the data are sent in plain text, so the connections between parunner and its workers
+  should be confined to a trusted network or tunneled (e.g. with ssh). -sandbox limits what the
+  instances can do, but not which binaries they run.
+`
+
+// getToken returns the token given in a flag, or in the environment if the flag is empty.
+func getToken(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(tokenEnv)
+}
+
+// workerAddrs returns the addresses of the workers specified by the -workers flag, starting
+// the loopback workers if there should be any.
+func workerAddrs() ([]string, string, error) {
+	token := getToken(*workerToken)
+	if *workers == "" {
+		return nil, "", nil
+	}
+	if !strings.HasPrefix(*workers, "loopback:") {
+		return strings.Split(*workers, ","), token, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(*workers, "loopback:"))
+	if err != nil || n < 1 {
+		return nil, "", fmt.Errorf("invalid number of loopback workers in %q", *workers)
+	}
+	// Other users of this machine can connect to the loopback workers, too.
+	if token == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, "", err
+		}
+		token = hex.EncodeToString(buf)
+	}
+	addrs := make([]string, n)
+	for i := range addrs {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, "", err
+		}
+		go runner.ServeWorker(l, token, *sandbox)
+		addrs[i] = l.Addr().String()
+	}
+	return addrs, token, nil
+}
+
+// isLoopback returns true if addr, given to net.Listen, only listens on a loopback address.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func workerMain(args []string) {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:7460", "Address to listen on")
+	sandbox := fs.Bool("sandbox", false, "Run the instances in a sandbox (Linux only)")
+	tokenFlag := fs.String("token", "", "Secret token that parunner has to send to run an instance (see -worker_token); defaults to $"+tokenEnv)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s worker [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Runs instances on behalf of a parunner started with -workers.\n")
+		fs.PrintDefaults()
+		fmt.Fprint(os.Stderr, workerUsage)
+	}
+	fs.Parse(args)
+	token := getToken(*tokenFlag)
+	if token == "" && !isLoopback(*listen) {
+		log.Fatalf("refusing to listen on %s without a token, as anyone could run commands as this user; see -token", *listen)
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("worker listening on %v", l.Addr())
+	log.Fatal(runner.ServeWorker(l, token, *sandbox))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/robryk/parunner/runner"
+)
+
+var reorder = flag.String("reorder", "none", "Delay the messages before requests to receive from any instance can receive them: none, random or max; see below")
+var reorderSeed = flag.Int64("reorder_seed", 1, "Seed for -reorder=random")
+var reorderMaxDelay = flag.Duration("reorder_max_delay", 10*time.Millisecond, "Maximal delay of a message in simulated time for -reorder=random")
+
+const reorderUsage = `Reordering messages:
+  There is no guarantee about the order in which messages sent between different pairs of instances
+  are received, but they are usually received in the order of their sending. -reorder=random delays
+  each message by a random amount of simulated time, up to -reorder_max_delay, before a request to
+  receive from any instance can receive it. -reorder=max delays the messages as long as possible.
+  The messages sent by one instance to another are never reordered, and the delays don't change the
+  instances' times. The same -reorder_seed gives the same delays.
+`
+
+// reorderAdversary returns the Adversary given by -reorder, or nil if there is none.
+func reorderAdversary() (*runner.Adversary, error) {
+	switch *reorder {
+	case "none":
+		return nil, nil
+	case "random":
+		if *reorderMaxDelay < 0 {
+			return nil, fmt.Errorf("invalid maximal delay: -reorder_max_delay=%v", *reorderMaxDelay)
+		}
+		return &runner.Adversary{Seed: *reorderSeed, MaxDelay: *reorderMaxDelay}, nil
+	case "max":
+		return &runner.Adversary{Maximal: true}, nil
+	}
+	return nil, fmt.Errorf("invalid reordering mode: -reorder=%s", *reorder)
+}
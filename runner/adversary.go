@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// An Adversary delays the messages before requests to receive from any instance can receive
+// them, so that such requests receive them in orders other than the order of their sending.
+// There is no ordering guarantee between messages sent between different pairs of instances,
+// but a program that relies on one anyway usually works, as the messages are normally
+// received in the order of their sending. The messages sent by one instance to another stay
+// in order.
+//
+// The delays only change which messages are received, not the simulated times. Requests to
+// receive from a given instance don't wait for the delayed messages.
+type Adversary struct {
+	// Seed seeds the random delays.
+	Seed int64
+	// MaxDelay is the maximal delay of a message, in simulated time. The delays are chosen
+	// uniformly from [0, MaxDelay].
+	MaxDelay time.Duration
+	// Maximal makes the messages delayed as long as possible instead: a message only becomes
+	// receivable once all the instances are waiting for messages or have finished. Then the
+	// message that was sent last becomes receivable, unless an earlier message between the
+	// same pair of instances is still delayed.
+	Maximal bool
+}
+
+type instancePair struct {
+	from, to int
+}
+
+// A delivery makes the next delayed message between a pair of instances receivable.
+type delivery struct {
+	at time.Duration
+	// order is the number of messages delayed before this one.
+	order int
+	pair  instancePair
+}
+
+// deliveryHeap is a min-heap of deliveries, ordered by time and then by order, or by the
+// reverse order if latestFirst is set.
+type deliveryHeap struct {
+	ds          []delivery
+	latestFirst bool
+}
+
+func (h deliveryHeap) Len() int { return len(h.ds) }
+func (h deliveryHeap) Less(i, j int) bool {
+	if h.latestFirst {
+		return h.ds[i].order > h.ds[j].order
+	}
+	if h.ds[i].at != h.ds[j].at {
+		return h.ds[i].at < h.ds[j].at
+	}
+	return h.ds[i].order < h.ds[j].order
+}
+func (h deliveryHeap) Swap(i, j int)       { h.ds[i], h.ds[j] = h.ds[j], h.ds[i] }
+func (h *deliveryHeap) Push(x interface{}) { h.ds = append(h.ds, x.(delivery)) }
+func (h *deliveryHeap) Pop() interface{} {
+	x := h.ds[len(h.ds)-1]
+	h.ds = h.ds[:len(h.ds)-1]
+	return x
+}
+
+// adversaryQueue is the delayQueue of the deliveries of an Adversary.
+type adversaryQueue struct {
+	adversary *Adversary
+	rng       *rand.Rand
+	queueSets []*queueSet
+	h         deliveryHeap
+	delayed   int
+	// last is the time of the last delivery between each pair of instances. It is only
+	// used for random delays.
+	last map[instancePair]time.Duration
+	// waiting holds the deliveries between each pair of instances that follow the one in
+	// the heap. It is only used for maximal delays, in which case there is at most one
+	// delivery between each pair of instances in the heap.
+	waiting map[instancePair][]delivery
+}
+
+func newAdversaryQueue(a *Adversary, queueSets []*queueSet) *adversaryQueue {
+	return &adversaryQueue{
+		adversary: a,
+		rng:       rand.New(rand.NewSource(a.Seed)),
+		queueSets: queueSets,
+		h:         deliveryHeap{latestFirst: a.Maximal},
+		last:      make(map[instancePair]time.Duration),
+		waiting:   make(map[instancePair][]delivery),
+	}
+}
+
+// delay schedules the delivery of a message sent at time t.
+func (aq *adversaryQueue) delay(from, to int, t time.Duration) {
+	p := instancePair{from, to}
+	d := delivery{order: aq.delayed, pair: p}
+	aq.delayed++
+	if aq.adversary.Maximal {
+		if ws, ok := aq.waiting[p]; ok {
+			aq.waiting[p] = append(ws, d)
+			return
+		}
+		aq.waiting[p] = nil
+		heap.Push(&aq.h, d)
+		return
+	}
+	d.at = t
+	if aq.adversary.MaxDelay > 0 {
+		d.at += time.Duration(aq.rng.Int63n(int64(aq.adversary.MaxDelay) + 1))
+	}
+	// The deliveries between a pair of instances happen in the order of the messages.
+	if last, ok := aq.last[p]; ok && last > d.at {
+		d.at = last
+	}
+	aq.last[p] = d.at
+	heap.Push(&aq.h, d)
+}
+
+func (aq *adversaryQueue) next() (time.Duration, bool) {
+	if aq.h.Len() == 0 {
+		return 0, false
+	}
+	if aq.adversary.Maximal {
+		// The deliveries happen only once there are no requests left to handle.
+		return math.MaxInt64, true
+	}
+	return aq.h.ds[0].at, true
+}
+
+func (aq *adversaryQueue) fire() (int, bool) {
+	d := heap.Pop(&aq.h).(delivery)
+	if aq.adversary.Maximal {
+		if ws := aq.waiting[d.pair]; len(ws) > 0 {
+			heap.Push(&aq.h, ws[0])
+			aq.waiting[d.pair] = ws[1:]
+		} else {
+			delete(aq.waiting, d.pair)
+		}
+	}
+	return d.pair.to, aq.queueSets[d.pair.to].show(d.pair.from)
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runCausality runs instances in which the message from instance 0 to instance 1 is sent
+// before, and causes, the message from instance 2 to instance 1. It returns the senders in
+// the order in which instance 1 has received the messages.
+func runCausality(t *testing.T, adversary *Adversary) []int {
+	var senders []int
+	_, err := RunInstances(context.Background(), Options{Adversary: adversary, Funcs: []NodeFunc{
+		func(ctx NodeContext) {
+			ctx.Send(1, []byte("first"))
+			ctx.Send(2, []byte("go"))
+		},
+		func(ctx NodeContext) {
+			for i := 0; i < 2; i++ {
+				sender, _ := ctx.Receive(-1)
+				senders = append(senders, sender)
+			}
+		},
+		func(ctx NodeContext) {
+			ctx.Receive(0)
+			ctx.Send(1, []byte("second"))
+		},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	return senders
+}
+
+func TestAdversaryMaximal(t *testing.T) {
+	if got, want := runCausality(t, nil), []int{0, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong order of messages without an adversary: got=%v, want=%v", got, want)
+	}
+	if got, want := runCausality(t, &Adversary{Maximal: true}), []int{2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong order of messages with a maximal adversary: got=%v, want=%v", got, want)
+	}
+	// Without delays, the messages are received in the order of their sending.
+	if got, want := runCausality(t, &Adversary{}), []int{0, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong order of messages with no delays: got=%v, want=%v", got, want)
+	}
+}
+
+// runInterleaved runs instances 1 and 2 that each send n messages to instance 0, which
+// receives them from any instance. It returns the messages in the order of receiving.
+func runInterleaved(t *testing.T, n int, adversary *Adversary) []string {
+	var received []string
+	send := func(ctx NodeContext) {
+		for i := 0; i < n; i++ {
+			ctx.Compute(time.Millisecond)
+			ctx.Send(0, []byte(fmt.Sprintf("%d/%d", ctx.NodeID(), i)))
+		}
+	}
+	_, err := RunInstances(context.Background(), Options{Adversary: adversary, Funcs: []NodeFunc{
+		func(ctx NodeContext) {
+			for i := 0; i < 2*n; i++ {
+				_, msg := ctx.Receive(-1)
+				received = append(received, string(msg))
+			}
+		},
+		send,
+		send,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	return received
+}
+
+func TestAdversaryRandom(t *testing.T) {
+	const n = 20
+	adversary := &Adversary{Seed: 1, MaxDelay: 10 * time.Millisecond}
+	received := runInterleaved(t, n, adversary)
+	next := map[byte]int{'1': 0, '2': 0}
+	for _, msg := range received {
+		if want := fmt.Sprintf("%c/%d", msg[0], next[msg[0]]); msg != want {
+			t.Fatalf("messages between a pair of instances reordered: got %q, want %q in %q", msg, want, received)
+		}
+		next[msg[0]]++
+	}
+	if again := runInterleaved(t, n, adversary); !reflect.DeepEqual(again, received) {
+		t.Errorf("different orders of messages with the same seed: %q and %q", received, again)
+	}
+	// Without the adversary, the instances' messages alternate.
+	if plain := runInterleaved(t, n, nil); reflect.DeepEqual(plain, received) {
+		t.Errorf("the adversary hasn't changed the order of messages: %q", received)
+	}
+}
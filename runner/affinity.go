@@ -0,0 +1,23 @@
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// startPinned calls start on a thread that is pinned to cpus, so that the processes it
+// starts inherit the pinning.
+func startPinned(cpus []int, start func() error) error {
+	errCh := make(chan error)
+	go func() {
+		// The thread is never unlocked, so it exits together with this goroutine instead of
+		// being reused with the changed affinity.
+		runtime.LockOSThread()
+		if err := pinThread(cpus); err != nil {
+			errCh <- fmt.Errorf("cannot pin to CPUs %v: %v", cpus, err)
+			return
+		}
+		errCh <- start()
+	}()
+	return <-errCh
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+func setAffinity(tid int, cpus []int) error {
+	var max int
+	for _, cpu := range cpus {
+		if cpu > max {
+			max = cpu
+		}
+	}
+	mask := make([]uint64, max/64+1)
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// pinThread pins the calling thread to cpus.
+func pinThread(cpus []int) error {
+	return setAffinity(0, cpus)
+}
+
+// PinProcess pins all the threads of the calling process to cpus. The threads started later inherit
+// the pinning.
+func PinProcess(cpus []int) error {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// The thread could have exited in the meantime.
+		if err := setAffinity(tid, cpus); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package runner
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+func TestStartPinned(t *testing.T) {
+	cmd := exec.Command("grep", "Cpus_allowed_list", "/proc/self/status")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := startPinned([]int{0}, cmd.Start); err != nil {
+		t.Fatalf("cannot start a pinned process: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("pinned process has failed: %v", err)
+	}
+	if got, want := out.String(), "Cpus_allowed_list:\t0\n"; got != want {
+		t.Errorf("wrong affinity of the pinned process: got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,16 @@
+// +build !linux
+
+package runner
+
+import "errors"
+
+var errNoAffinity = errors.New("CPU pinning is not supported on this platform")
+
+func pinThread(cpus []int) error {
+	return errNoAffinity
+}
+
+// PinProcess pins all the threads of the calling process to cpus. It is only supported on Linux.
+func PinProcess(cpus []int) error {
+	return errNoAffinity
+}
@@ -0,0 +1,37 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// testerPath is the path of internal/tester, which is built for the tests.
+var testerPath string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "parunner-test")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't create a directory for the tester: %v\n", err)
+		os.Exit(1)
+	}
+	testerPath = filepath.Join(dir, "tester")
+	if runtime.GOOS == "windows" {
+		testerPath += ".exe"
+	}
+	cmd := exec.Command("go", "build", "-o", testerPath, "../internal/tester")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	code := 1
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "can't build the tester: %v\n", err)
+	} else {
+		code = m.Run()
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
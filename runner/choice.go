@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Chooser decides which message a request to receive from any instance gets, when
+// messages from several instances are waiting for the receiver. A run with a Chooser that
+// decides in the same way is repeatable as long as the instances only depend on the
+// messages they receive.
+type Chooser interface {
+	// Choose returns the instance whose message is received. The request is the index-th
+	// request of the receiver to receive from any instance, counting from 0. Senders are the
+	// instances whose messages are waiting, in increasing order; there are at least two of
+	// them.
+	Choose(receiver, index int, senders []int) int
+}
+
+// A Choice is a decision made by a Chooser.
+type Choice struct {
+	Receiver, Index, Sender int
+	// Options are the senders that the choice was made from. They are not a part of the
+	// text form of the choice.
+	Options []int
+}
+
+func (c Choice) String() string {
+	return fmt.Sprintf("%d.%d=%d", c.Receiver, c.Index, c.Sender)
+}
+
+// FormatChoices returns the text form of a list of choices, which is accepted by
+// ParseChoices. It is e.g. 1.0=2,1.1=0 if the first receive-any request of instance 1
+// received the message from instance 2 and the second one from instance 0.
+func FormatChoices(choices []Choice) string {
+	parts := make([]string, len(choices))
+	for i, c := range choices {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseChoices parses the text form of a list of choices.
+func ParseChoices(s string) ([]Choice, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var choices []Choice
+	for _, part := range strings.Split(s, ",") {
+		dot := strings.Index(part, ".")
+		eq := strings.Index(part, "=")
+		if dot == -1 || eq < dot {
+			return nil, fmt.Errorf("invalid choice %q: expected receiver.index=sender", part)
+		}
+		var c Choice
+		var err1, err2, err3 error
+		c.Receiver, err1 = strconv.Atoi(part[:dot])
+		c.Index, err2 = strconv.Atoi(part[dot+1 : eq])
+		c.Sender, err3 = strconv.Atoi(part[eq+1:])
+		if err1 != nil || err2 != nil || err3 != nil || c.Receiver < 0 || c.Index < 0 || c.Sender < 0 {
+			return nil, fmt.Errorf("invalid choice %q: expected receiver.index=sender", part)
+		}
+		choices = append(choices, c)
+	}
+	return choices, nil
+}
+
+type choicePoint struct {
+	receiver, index int
+}
+
+// A Schedule is a Chooser that makes the choices it is given and records all the choices
+// it makes. Where it isn't given a choice, or the sender of the given choice has no message
+// waiting, it chooses the lowest sender, or a random one if Rand is non-nil.
+type Schedule struct {
+	// Rand, if non-nil, is used to make the choices that aren't given.
+	Rand *rand.Rand
+
+	mu     sync.Mutex
+	forced map[choicePoint]int
+	made   []Choice
+}
+
+// NewSchedule returns a Schedule that makes the given choices.
+func NewSchedule(choices []Choice) *Schedule {
+	s := &Schedule{forced: make(map[choicePoint]int)}
+	for _, c := range choices {
+		s.forced[choicePoint{c.Receiver, c.Index}] = c.Sender
+	}
+	return s
+}
+
+func (s *Schedule) Choose(receiver, index int, senders []int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sender := senders[0]
+	if s.Rand != nil {
+		sender = senders[s.Rand.Intn(len(senders))]
+	}
+	if forced, ok := s.forced[choicePoint{receiver, index}]; ok {
+		for _, id := range senders {
+			if id == forced {
+				sender = forced
+			}
+		}
+	}
+	s.made = append(s.made, Choice{Receiver: receiver, Index: index, Sender: sender, Options: append([]int(nil), senders...)})
+	return sender
+}
+
+// Choices returns the choices made so far, in the order in which they were made.
+func (s *Schedule) Choices() []Choice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Choice(nil), s.made...)
+}
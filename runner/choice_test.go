@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseChoices(t *testing.T) {
+	choices, err := ParseChoices("1.0=2,1.1=0,3.12=4")
+	if err != nil {
+		t.Fatalf("unexpected error from ParseChoices: %v", err)
+	}
+	want := []Choice{{Receiver: 1, Index: 0, Sender: 2}, {Receiver: 1, Index: 1, Sender: 0}, {Receiver: 3, Index: 12, Sender: 4}}
+	if !reflect.DeepEqual(choices, want) {
+		t.Errorf("wrong choices: got=%v, want=%v", choices, want)
+	}
+	if got, want := FormatChoices(choices), "1.0=2,1.1=0,3.12=4"; got != want {
+		t.Errorf("wrong text form of choices: got=%q, want=%q", got, want)
+	}
+	if choices, err := ParseChoices(""); err != nil || len(choices) != 0 {
+		t.Errorf("wrong result of parsing no choices: %v, %v", choices, err)
+	}
+	for _, s := range []string{"1", "1.0", "1=0.2", "a.0=1", "1.-1=2", "1.0=2,"} {
+		if _, err := ParseChoices(s); err == nil {
+			t.Errorf("ParseChoices(%q) unexpectedly succeeded", s)
+		}
+	}
+}
+
+// runReceiveAny runs instance 0 that receives from any instance after the other n-1
+// instances have sent it their messages, and returns the order of senders it has seen.
+func runReceiveAny(t *testing.T, n int, chooser Chooser) []int {
+	var senders []int
+	fs := make([]NodeFunc, n)
+	fs[0] = func(ctx NodeContext) {
+		ctx.Compute(time.Millisecond)
+		for i := 1; i < ctx.NumberOfNodes(); i++ {
+			sender, _ := ctx.Receive(-1)
+			senders = append(senders, sender)
+		}
+	}
+	for i := 1; i < n; i++ {
+		fs[i] = func(ctx NodeContext) {
+			ctx.Send(0, []byte(fmt.Sprint(ctx.NodeID())))
+		}
+	}
+	if _, err := RunInstances(context.Background(), Options{Funcs: fs, Chooser: chooser}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	return senders
+}
+
+func TestSchedule(t *testing.T) {
+	s := NewSchedule(nil)
+	if got, want := runReceiveAny(t, 4, s), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the default schedule received in wrong order: got=%v, want=%v", got, want)
+	}
+	want := []Choice{{0, 0, 1, []int{1, 2, 3}}, {0, 1, 2, []int{2, 3}}}
+	if got := s.Choices(); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong choices recorded: got=%v, want=%v", got, want)
+	}
+
+	// A choice of a sender without a message waiting is ignored.
+	s = NewSchedule([]Choice{{Receiver: 0, Index: 0, Sender: 3}, {Receiver: 0, Index: 1, Sender: 3}})
+	if got, want := runReceiveAny(t, 4, s), []int{3, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the given schedule received in wrong order: got=%v, want=%v", got, want)
+	}
+	if got, want := FormatChoices(s.Choices()), "0.0=3,0.1=1"; got != want {
+		t.Errorf("wrong choices recorded: got=%q, want=%q", got, want)
+	}
+}
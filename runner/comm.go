@@ -0,0 +1,276 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/robryk/parunner/wire"
+)
+
+type Message struct {
+	Source   int
+	Target   int
+	SendTime time.Duration
+	Message  []byte
+	// Stored is set instead of Message if the payload was spilled to a MessageStore.
+	Stored *StoredPayload
+}
+
+// Len returns the length of the message's payload.
+func (m *Message) Len() int {
+	if m.Stored != nil {
+		return m.Stored.Len()
+	}
+	return len(m.Message)
+}
+
+// errNoResponse is returned when the message router stops before responding to a receive
+// request, which happens when the run is being aborted.
+var errNoResponse = errors.New("received no response for a receive request")
+
+// ErrMessageCount is returned when an instance exceeds the per-instance message count limit.
+// It is usually encapsulated in an InstanceError that specifies the instance ID.
+type ErrMessageCount struct {
+	Limit int
+}
+
+func (err ErrMessageCount) Error() string {
+	return fmt.Sprintf("sent message count limit (%d) exceeded", err.Limit)
+}
+
+// ErrMessageSize is returned when an instance exceeds the per-instance total messages size limit.
+// It is usually encapsulated in an InstanceError that specifies the instance ID.
+type ErrMessageSize struct {
+	Limit int
+}
+
+func (err ErrMessageSize) Error() string {
+	return fmt.Sprintf("total sent message size limit (%d bytes) exceeded", err.Limit)
+}
+
+// ErrTimeLimit is returned when the simulated time of an instance exceeds its time limit.
+// It is usually encapsulated in an InstanceError that specifies the instance ID.
+type ErrTimeLimit struct {
+	Limit time.Duration
+}
+
+func (err ErrTimeLimit) Error() string {
+	return fmt.Sprintf("simulated time limit (%v) exceeded", err.Limit)
+}
+
+// writeMessage writes a response carrying message. If shm is non-nil and ready, the payload
+// is passed through it.
+func writeMessage(e *wire.Encoder, message *Message, shm *sharedRegion) error {
+	if shm != nil && shm.ready() {
+		var payload io.Reader = bytes.NewReader(message.Message)
+		if message.Stored != nil {
+			payload = message.Stored.Reader()
+		}
+		if err := shm.putResponse(payload, message.Len()); err != nil {
+			return err
+		}
+		return e.WriteResponse(&wire.Response{Source: message.Source, Shared: true, Length: message.Len()})
+	}
+	if message.Stored != nil {
+		return e.WriteResponseFrom(message.Source, message.Stored.Len(), message.Stored.Reader())
+	}
+	return e.WriteResponse(&wire.Response{Source: message.Source, Payload: message.Message})
+}
+
+func writeHeader(e *wire.Encoder, id int, instanceCount int) error {
+	return e.WriteHeader(&wire.Header{NodeCount: instanceCount, NodeID: id})
+}
+
+const (
+	requestSend = iota
+	requestRecv
+	requestRecvAny
+	// requestNop
+)
+
+type request struct {
+	requestType int
+	time        time.Duration
+
+	// for requestSend:
+	destination int
+	message     []byte
+	stored      *StoredPayload
+
+	// for requestRecv:
+	source int
+}
+
+func (req request) messageLen() int {
+	if req.stored != nil {
+		return req.stored.Len()
+	}
+	return len(req.message)
+}
+
+func (req request) hasResponse() bool {
+	switch req.requestType {
+	case requestRecv:
+		return true
+	case requestRecvAny:
+		return true
+	default:
+		return false
+	}
+}
+
+type response struct {
+	message *Message
+}
+
+// newDecoder returns a decoder of requests from an instance in a run of n instances that
+// may send at most sizeLimit bytes in total (0 means no limit).
+func newDecoder(r io.Reader, n int, sizeLimit int) *wire.Decoder {
+	d := wire.NewDecoder(r)
+	if sizeLimit > 0 {
+		d.MaxMessageSize = sizeLimit
+	}
+	d.NodeLimit = n
+	return d
+}
+
+// readPayload reads a payload of a given length from r. If store is non-nil, the payload
+// is streamed into it, instead of being read into memory as a whole.
+func readPayload(r io.Reader, length int, store *MessageStore) ([]byte, *StoredPayload, error) {
+	if store != nil {
+		return store.Store(r, length)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	return buf.Bytes(), nil, nil
+}
+
+// readRequest reads a single request. Payloads of send requests are read from the
+// shared memory region shm for shared sends and from the decoder's stream otherwise.
+func readRequest(d *wire.Decoder, store *MessageStore, shm *sharedRegion) (*request, error) {
+	req, err := d.ReadRequestHeader()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case req.Op == wire.OpSend || req.Op == wire.OpSendShared:
+		r := &request{requestType: requestSend, time: req.Time, destination: req.Peer}
+		payload := d.Payload(req.Length)
+		if req.Op == wire.OpSendShared {
+			if shm == nil {
+				return nil, fmt.Errorf("shared memory send from an instance without a shared memory region")
+			}
+			if payload, err = shm.sendPayload(req.Length); err != nil {
+				return nil, err
+			}
+			defer shm.consume(req.Length)
+		}
+		if r.message, r.stored, err = readPayload(payload, req.Length, store); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case req.Peer == -1:
+		return &request{requestType: requestRecvAny, time: req.Time}, nil
+	default:
+		return &request{requestType: requestRecv, time: req.Time, source: req.Peer}, nil
+	}
+}
+
+func (i *Instance) communicate(r io.Reader, w io.Writer, reqCh chan<- *request, respCh <-chan *response) error {
+	i.TimeBlocked = time.Duration(0)
+	// TODO: Figure out what errors should be returned from this function. We currently error if the instance fails to read the header (which is mitigated by delaying the closure of other ends of the pipes), for example.
+	d := newDecoder(r, i.TotalInstances, i.MessageSizeLimit)
+	e := wire.NewEncoder(w)
+	if err := writeHeader(e, i.ID, i.TotalInstances); err != nil {
+		return err
+	}
+	// The requests that the process has sent before finishing still have to be passed on,
+	// so we don't stop if it finishes while we're waiting for a slot.
+	i.waitForSlot(0)
+	for {
+		req, err := readRequest(d, i.Store, i.shm)
+		if err != nil {
+			if err == io.EOF {
+				//return nil
+			}
+			return err
+		}
+		req.time = scaleTime(req.time, i.TimeScale)
+		i.lastCPUTime = req.time
+		i.hasRequested = true
+		req.time += i.TimeBlocked
+		if req.requestType == requestSend {
+			i.MessagesSent++
+			if i.MessageCountLimit > 0 && i.MessagesSent > i.MessageCountLimit {
+				return ErrMessageCount{Limit: i.MessageCountLimit}
+			}
+			i.MessageBytesSent += req.messageLen()
+			if i.MessageSizeLimit > 0 && i.MessageBytesSent > i.MessageSizeLimit {
+				return ErrMessageSize{Limit: i.MessageSizeLimit}
+			}
+		}
+		currentTime := req.time
+		if i.TimeLimit > 0 && currentTime > i.TimeLimit {
+			return ErrTimeLimit{Limit: i.TimeLimit}
+		}
+		i.setSimulatedTime(currentTime)
+		hasResponse := req.hasResponse()
+		if hasResponse {
+			// The instance waits for the response, so it doesn't need its slot.
+			i.yieldSlot(false)
+			reqCh <- req
+		} else if i.Scheduler == nil {
+			reqCh <- req
+		} else {
+			select {
+			case reqCh <- req:
+			default:
+				// The router is busy with other instances, which may need our slot to make
+				// progress. We pause the instance until we can pass the request on.
+				i.yieldSlot(true)
+				reqCh <- req
+				i.waitForSlot(currentTime)
+			}
+		}
+		if hasResponse {
+			var resp *response
+			var ok bool
+			select {
+			case resp, ok = <-respCh:
+			case <-i.waitDone:
+				// The process has been killed while waiting. The message it waits for might
+				// never arrive, e.g. if its sender waits for this instance to fail.
+				return io.EOF
+			}
+			if !ok {
+				return errNoResponse
+			}
+			resumeTime := currentTime
+			if resp.message.SendTime > currentTime {
+				i.TimeBlocked += resp.message.SendTime - currentTime
+				resumeTime = resp.message.SendTime
+			}
+			i.setSimulatedTime(resumeTime)
+			err := io.EOF
+			if i.waitForSlot(resumeTime) {
+				err = writeMessage(e, resp.message, i.shm)
+			}
+			if resp.message.Stored != nil {
+				resp.message.Stored.Release()
+			} else if i.Store != nil {
+				i.Store.ReleaseMemory(len(resp.message.Message))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
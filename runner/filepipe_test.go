@@ -1,4 +1,4 @@
-package main
+package runner
 
 import (
 	"bytes"
@@ -85,12 +85,13 @@ func TestFilePipeConcurrent(t *testing.T) {
 		wg.Add(1)
 		fpr := fp.Reader()
 		go func(fpr io.Reader) {
+			defer wg.Done()
 			buf, err := ioutil.ReadAll(fpr)
 			if err != nil {
-				t.Fatalf("Failed to read from a filepipe reader: %v", err)
+				t.Errorf("Failed to read from a filepipe reader: %v", err)
+				return
 			}
 			expectEqual(t, buf, want)
-			wg.Done()
 		}(fpr)
 	}
 	_, err = io.Copy(fp, testReader())
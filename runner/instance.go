@@ -0,0 +1,394 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Instance struct {
+	ID             int
+	TotalInstances int
+	Cmd            *exec.Cmd
+	// Worker, if non-empty, is the address of a parunner worker that should run Cmd
+	// instead of this process. WorkerToken is the secret that the worker requires.
+	Worker      string
+	WorkerToken string
+	// Func, if non-nil, is run in a goroutine of this process instead of Cmd. Only the
+	// standard outputs of Cmd are used then.
+	Func NodeFunc
+
+	RequestChan  chan *request
+	ResponseChan chan *response
+
+	// StopAtStart makes the instance stop (with SIGSTOP) before it executes its binary, so
+	// that a debugger can attach to it. Its PID is reported in the InstanceStarted event.
+	StopAtStart bool
+	// TimeFromRequests makes TimeRunning the CPU time reported by the instance in its last
+	// request, instead of the CPU time measured by the OS, for an instance whose measured
+	// time includes a debugger or a wrapper it is run under. An instance that hasn't made any
+	// requests keeps the measured time, as it has nothing better to report.
+	TimeFromRequests bool
+	// TimeScale multiplies all the CPU times of the instance, both the ones from its requests
+	// and TimeRunning, to simulate a machine of a different speed. Zero means no scaling.
+	TimeScale float64
+	// MessageCountLimit and MessageSizeLimit limit the number and the total size of the
+	// messages sent by the instance. Zero means no limit.
+	MessageCountLimit int
+	MessageSizeLimit  int
+	// TimeLimit limits the simulated time of the instance. It is checked at each request of
+	// the instance and once it finishes, so an instance that computes without communicating
+	// is only stopped by it once it finishes. Zero means no limit.
+	TimeLimit time.Duration
+	// MemoryLimit, if positive, limits the memory of the instance, in bytes. It is enforced
+	// by a cgroup created under the cgroup of this process, which has to be writable (Linux
+	// only). It only applies to instances run locally.
+	MemoryLimit int64
+	// Sandbox makes the instance run in its own namespaces with a private working directory
+	// and a system call filter (Linux only). It only applies to
+	// instances run locally.
+	Sandbox bool
+	// SharedMemory makes the instance receive message payloads through a shared memory
+	// region instead of its pipe (Linux only). It only applies to instances run locally.
+	SharedMemory bool
+	// CPUs, if non-nil, are the CPUs that the instance is pinned to. It only applies to
+	// instances run locally.
+	CPUs []int
+
+	// Scheduler, if non-nil, limits the number of instances that compute at the same time.
+	// The instance is paused while it doesn't have a slot.
+	Scheduler *Scheduler
+
+	// DetectToolReports makes the instance fail if its stderr contains an error report of
+	// valgrind or of a sanitizer.
+	DetectToolReports bool
+
+	// Store, if non-nil, holds the payloads of messages sent by this instance until they are
+	// received. All instances that exchange messages must share the same store.
+	Store *MessageStore
+
+	// Observer, if non-nil, is notified when the instance starts and finishes.
+	Observer Observer
+
+	// The following fields should not be accessed until the Instance is Waited for.
+	MessagesSent     int
+	MessageBytesSent int
+	TimeRunning      time.Duration
+	TimeBlocked      time.Duration
+	Termination      Termination
+
+	process process
+	shm     *sharedRegion
+	reports *reportDetector
+	// holdsSlot is set if the instance has a slot of its Scheduler. paused is set if the
+	// instance's process is paused. Both are only used by the communication goroutine
+	// once the instance is started.
+	holdsSlot bool
+	paused    bool
+	// lastCPUTime is the CPU time reported by the instance in its most recent request.
+	// hasRequested is set once the instance has made a request.
+	lastCPUTime  time.Duration
+	hasRequested bool
+	// simulatedTime is the simulated time of the instance as of its most recent request or
+	// response. It is accessed atomically, as the output sinks read it.
+	simulatedTime int64
+
+	errOnce sync.Once
+	err     error
+	// commErr is set if the instance has failed because of an error in its communication.
+	commErr error
+	// outputErr is set if the instance has failed because it has exceeded an output limit
+	// or has written output that it wasn't allowed to.
+	outputErr error
+	// waitErr is the error returned by the process' Wait.
+	waitErr  error
+	waitDone chan bool
+	commDone chan bool
+	// finished is closed once the instance's Termination is known.
+	finished chan bool
+
+	mu         sync.Mutex
+	killReason string
+}
+
+func (instance *Instance) newProcess() (process, error) {
+	if instance.Func != nil {
+		return newFuncProcess(instance.Func, instance.Cmd.Stdout, instance.Cmd.Stderr), nil
+	}
+	if instance.Worker != "" {
+		return &remoteProcess{addr: instance.Worker, token: instance.WorkerToken, cmd: instance.Cmd}, nil
+	}
+	p := &localProcess{cmd: instance.Cmd, stopAtStart: instance.StopAtStart, sandbox: instance.Sandbox, cpus: instance.CPUs, memoryLimit: instance.MemoryLimit}
+	if instance.SharedMemory {
+		var err error
+		if instance.shm, err = newSharedRegion(); err != nil {
+			return nil, err
+		}
+		p.shm = instance.shm.File
+	}
+	return p, nil
+}
+
+// Start starts the instance. If ctx is done before the instance finishes, the instance is
+// killed and Wait returns ctx.Err().
+func (instance *Instance) Start(ctx context.Context) error {
+	instance.waitDone = make(chan bool)
+	instance.commDone = make(chan bool)
+	instance.finished = make(chan bool)
+
+	for _, w := range []io.Writer{instance.Cmd.Stdout, instance.Cmd.Stderr} {
+		if cs, ok := w.(ClockedSink); ok {
+			cs.SetClock(instance.SimulatedTime)
+		}
+	}
+
+	instance.Cmd.Stdout = instance.watchOutput(instance.Cmd.Stdout)
+	instance.Cmd.Stderr = instance.watchOutput(instance.Cmd.Stderr)
+
+	if instance.DetectToolReports {
+		instance.reports = &reportDetector{}
+		if instance.Cmd.Stderr == nil {
+			instance.Cmd.Stderr = instance.reports
+		} else {
+			instance.Cmd.Stderr = io.MultiWriter(instance.reports, instance.Cmd.Stderr)
+		}
+	}
+
+	var err error
+	if instance.process, err = instance.newProcess(); err != nil {
+		return err
+	}
+	requests, responses, err := instance.process.Start()
+	if err != nil {
+		if instance.shm != nil {
+			instance.shm.Release()
+		}
+		return err
+	}
+	// The instance's other events can only be reported once its goroutines are started.
+	started := Event{Type: InstanceStarted, Instance: instance.ID}
+	if p, ok := instance.process.(*localProcess); ok {
+		started.PID = p.cmd.Process.Pid
+	}
+	observe(instance.Observer, started)
+	if instance.Scheduler != nil {
+		if instance.Scheduler.TryAcquire() {
+			instance.holdsSlot = true
+		} else {
+			// If the process can't be paused, it just runs without a slot.
+			instance.paused = instance.pause() == nil
+		}
+	}
+
+	go func() {
+		if err := instance.communicate(requests, responses, instance.RequestChan, instance.ResponseChan); err != nil {
+			instance.errOnce.Do(func() {
+				instance.err = err
+				instance.commErr = err
+			})
+			instance.process.Kill()
+		}
+		instance.yieldSlot(false)
+		requests.Close()
+		responses.Close()
+		if instance.shm != nil {
+			instance.shm.Release()
+		}
+		if instance.TimeFromRequests && instance.hasRequested {
+			<-instance.waitDone
+			instance.TimeRunning = instance.lastCPUTime
+		}
+		close(instance.commDone)
+	}()
+	go func() {
+		timeRunning, err := instance.process.Wait()
+		instance.waitErr = err
+		instance.errOnce.Do(func() {
+			instance.err = err
+		})
+		instance.TimeRunning = scaleTime(timeRunning, instance.TimeScale)
+		// We are doing it this late in order to delay error reports from communicate that are
+		// a result of the pipes closing (broken pipe on write pipe, EOF on read pipe). We
+		// do want to ignore some of those errors (e.g. broken pipe at the very beginning, which
+		// indicates that the program didn't use the communication library at all), so currently
+		// we ignore all of them.
+		// TODO: Do we want to ignore then also when the program has terminated with no errors?
+		//       Example: program has exited in the middle of sending a message.
+		instance.process.Close()
+		close(instance.waitDone)
+	}()
+	go func() {
+		<-instance.waitDone
+		<-instance.commDone
+		instance.setTermination()
+		observe(instance.Observer, Event{Type: InstanceFinished, Instance: instance.ID, Time: instance.SimulatedTime(), Termination: &instance.Termination})
+		close(instance.finished)
+	}()
+	go instance.killOnDone(ctx)
+	return nil
+}
+
+// Wait waits for the instance to finish. If ctx is done before that, the instance is killed
+// and Wait returns ctx.Err() once it has finished.
+func (i *Instance) Wait(ctx context.Context) error {
+	i.killOnDone(ctx)
+	<-i.finished
+	return i.err
+}
+
+// pausableProcess is a process that can be paused and resumed.
+type pausableProcess interface {
+	Pause() error
+	Resume() error
+}
+
+func (i *Instance) pause() error {
+	if p, ok := i.process.(pausableProcess); ok {
+		return p.Pause()
+	}
+	return errors.New("the instance can't be paused")
+}
+
+// yieldSlot gives the instance's slot up, pausing its process first if pause is set.
+func (i *Instance) yieldSlot(pause bool) {
+	if !i.holdsSlot {
+		return
+	}
+	if pause {
+		i.paused = i.pause() == nil
+	}
+	i.holdsSlot = false
+	i.Scheduler.Release()
+}
+
+// outputWatcher is a writer that kills the instance once it exceeds an output limit or
+// writes output that it isn't allowed to.
+type outputWatcher struct {
+	io.Writer
+	instance *Instance
+}
+
+// watchOutput makes the instance watch for the output errors of w. Files are left alone,
+// so that the process can write to them directly.
+func (instance *Instance) watchOutput(w io.Writer) io.Writer {
+	switch w.(type) {
+	case nil, *os.File:
+		return w
+	}
+	return outputWatcher{w, instance}
+}
+
+func (w outputWatcher) Write(buf []byte) (int, error) {
+	n, err := w.Writer.Write(buf)
+	switch err.(type) {
+	case ErrOutputLimit, ErrContestOutput:
+		w.instance.errOnce.Do(func() {
+			w.instance.err = err
+			w.instance.outputErr = err
+		})
+		w.instance.process.Kill()
+	}
+	return n, err
+}
+
+// SimulatedTime returns the simulated time of the instance as of its most recent request
+// or response. It can be called while the instance is running.
+func (i *Instance) SimulatedTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&i.simulatedTime))
+}
+
+func (i *Instance) setSimulatedTime(t time.Duration) {
+	atomic.StoreInt64(&i.simulatedTime, int64(t))
+}
+
+// waitForSlot waits until the instance, whose simulated time is t, has a slot and resumes
+// its process if it was paused. It returns false if the process has finished in the meantime.
+func (i *Instance) waitForSlot(t time.Duration) bool {
+	if i.Scheduler == nil || i.holdsSlot {
+		return true
+	}
+	if !i.Scheduler.Acquire(t, i.waitDone) {
+		return false
+	}
+	i.holdsSlot = true
+	if i.paused {
+		i.process.(pausableProcess).Resume()
+		i.paused = false
+	}
+	return true
+}
+
+// setTermination fills in i.Termination once the instance has finished.
+func (i *Instance) setTermination() {
+	t := processTermination(i.waitErr)
+	if i.commErr != nil {
+		// The process was killed because of the communication error, so its termination
+		// is only the consequence.
+		ct := commTermination(i.commErr)
+		ct.Exited, ct.ExitCode, ct.Signal, ct.CoreDumped = t.Exited, t.ExitCode, t.Signal, t.CoreDumped
+		t = ct
+	}
+	switch i.outputErr.(type) {
+	case ErrOutputLimit:
+		t.OutputLimit = i.outputErr.Error()
+	case ErrContestOutput:
+		t.UnexpectedOutput = i.outputErr.Error()
+	}
+	i.mu.Lock()
+	if i.killReason != "" && !t.Exited && t.Killed == "" {
+		t.Killed = i.killReason
+	}
+	i.mu.Unlock()
+	if i.reports != nil {
+		if report := i.reports.Report(); report != nil {
+			t.ToolReport, t.ToolReportLine = report.Tool, report.Line
+			// A report explains a failure of the process better than its exit status.
+			if i.err == nil || i.err == i.waitErr {
+				i.err = *report
+			}
+		}
+	}
+	if i.TimeLimit > 0 && i.err == nil && i.TimeRunning+i.TimeBlocked > i.TimeLimit {
+		err := ErrTimeLimit{Limit: i.TimeLimit}
+		i.err = err
+		t.TimeLimit = err.Error()
+	}
+	i.Termination = t
+}
+
+// killOnDone kills the instance if ctx is done before the instance finishes. It returns
+// once either has happened.
+func (i *Instance) killOnDone(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		i.errOnce.Do(func() {
+			i.err = ctx.Err()
+		})
+		i.killBecause(ctx.Err().Error())
+	case <-i.waitDone:
+	}
+}
+
+var ErrKilled = errors.New("killed by an explicit request")
+
+func (i *Instance) Kill() error {
+	return i.killBecause(ErrKilled.Error())
+}
+
+// killBecause kills the instance, recording the reason in its Termination.
+func (i *Instance) killBecause(reason string) error {
+	i.errOnce.Do(func() {
+		i.err = ErrKilled
+	})
+	i.mu.Lock()
+	if i.killReason == "" {
+		i.killReason = reason
+	}
+	i.mu.Unlock()
+	return i.process.Kill()
+}
@@ -1,7 +1,8 @@
-package main
+package runner
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -14,9 +15,9 @@ import (
 func checkedWait(t *testing.T, instance *Instance) error {
 	ch := make(chan error, 1)
 	go func() {
-		ch <- instance.Wait()
+		ch <- instance.Wait(context.Background())
 	}()
-	err := instance.Wait()
+	err := instance.Wait(context.Background())
 	if err1 := <-ch; err1 != err {
 		t.Errorf("Instance.Wait() gave contradictory return values: %v != %v", err, err1)
 	}
@@ -25,7 +26,7 @@ func checkedWait(t *testing.T, instance *Instance) error {
 
 func TestInstanceSuccess(t *testing.T) {
 	instance := &Instance{ID: 0, TotalInstances: 1, Cmd: exec.Command(testerPath)}
-	if err := instance.Start(); err != nil {
+	if err := instance.Start(context.Background()); err != nil {
 		t.Fatalf("error starting an instance of tester: %v", err)
 	}
 	if err := checkedWait(t, instance); err != nil {
@@ -37,7 +38,7 @@ func TestInstanceFailure(t *testing.T) {
 	cmd := exec.Command(testerPath)
 	cmd.Stdin = strings.NewReader("Q 1\n")
 	instance := &Instance{ID: 0, TotalInstances: 1, Cmd: cmd}
-	if err := instance.Start(); err != nil {
+	if err := instance.Start(context.Background()); err != nil {
 		t.Fatalf("error starting an instance of tester: %v", err)
 	}
 	if err := checkedWait(t, instance); err == nil {
@@ -52,7 +53,7 @@ func TestInstanceKill(t *testing.T) {
 	}
 	cmd.Stdout = ioutil.Discard
 	instance := &Instance{ID: 0, TotalInstances: 1, Cmd: cmd}
-	if err := instance.Start(); err != nil {
+	if err := instance.Start(context.Background()); err != nil {
 		t.Fatalf("error starting an instance of tester: %v", err)
 	}
 	waitChan := make(chan error)
@@ -71,6 +72,36 @@ func TestInstanceKill(t *testing.T) {
 	}
 }
 
+func TestInstanceCancel(t *testing.T) {
+	cmd := exec.Command(testerPath)
+	cmd.Stdin = strings.NewReader("H\n")
+	instance := &Instance{ID: 0, TotalInstances: 1, Cmd: cmd}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := instance.Start(ctx); err != nil {
+		t.Fatalf("error starting an instance of tester: %v", err)
+	}
+	cancel()
+	if err := checkedWait(t, instance); err != context.Canceled {
+		t.Errorf("a canceled instance has finished with error %v, instead of %v", err, context.Canceled)
+	}
+	if got, want := instance.Termination.String(), "killed (context canceled)"; got != want {
+		t.Errorf("wrong termination of a canceled instance: got=%q, want=%q", got, want)
+	}
+
+	// Wait kills the instance, too.
+	cmd = exec.Command(testerPath)
+	cmd.Stdin = strings.NewReader("H\n")
+	instance = &Instance{ID: 0, TotalInstances: 1, Cmd: cmd}
+	if err := instance.Start(context.Background()); err != nil {
+		t.Fatalf("error starting an instance of tester: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := instance.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("an instance waited for with a timeout has finished with error %v, instead of %v", err, context.DeadlineExceeded)
+	}
+}
+
 func TestInstanceComm(t *testing.T) {
 	if _, err := os.Stat(testerPath); err != nil {
 		t.Fatalf("can't find tester binary: %v", err)
@@ -94,7 +125,7 @@ func TestInstanceComm(t *testing.T) {
 			RequestChan:    make(chan *request, 1),
 			ResponseChan:   make(chan *response, 1),
 		}
-		if err := instance.Start(); err != nil {
+		if err := instance.Start(context.Background()); err != nil {
 			t.Errorf("test %s: error starting an instance of tester: %v", tc.name, err)
 			return
 		}
@@ -180,7 +211,8 @@ func TestInstanceComm(t *testing.T) {
 
 // Stop receiving in the middle of a message
 func TestInstanceBrokenPipe(t *testing.T) {
-	cmd := exec.Command(hangerPath)
+	cmd := exec.Command(testerPath)
+	cmd.Stdin = strings.NewReader("ER*\n")
 	instance := &Instance{
 		ID:             0,
 		TotalInstances: 2,
@@ -188,8 +220,8 @@ func TestInstanceBrokenPipe(t *testing.T) {
 		RequestChan:    make(chan *request, 1),
 		ResponseChan:   make(chan *response, 1),
 	}
-	if err := instance.Start(); err != nil {
-		t.Fatalf("error starting an instance of hanger: %v", err)
+	if err := instance.Start(context.Background()); err != nil {
+		t.Fatalf("error starting an instance of tester: %v", err)
 	}
 	go func() {
 		for _ = range instance.RequestChan {
@@ -203,6 +235,47 @@ func TestInstanceBrokenPipe(t *testing.T) {
 		Message:  []byte("abcdefghijlkmnopqrstuvwxyz"), // this message will take >20 bytes on the wire
 	}}
 	if err := checkedWait(t, instance); err != nil {
-		t.Fatalf("error running an instance of hanger: %v", err)
+		t.Fatalf("error running an instance of tester: %v", err)
+	}
+}
+
+func TestInstanceTimeFromRequests(t *testing.T) {
+	for _, tc := range []struct {
+		input   string
+		minTime time.Duration
+		maxTime time.Duration
+	}{
+		// The time reported in the last request replaces the measured one.
+		{input: "C\nT5\nSafoo\n", minTime: 5 * time.Millisecond, maxTime: 5 * time.Millisecond},
+		// An instance that doesn't communicate keeps the measured time.
+		{input: "C\n", minTime: 20 * time.Millisecond},
+	} {
+		cmd := exec.Command(testerPath)
+		cmd.Stdin = strings.NewReader(tc.input)
+		cmd.Stdout = ioutil.Discard
+		instance := &Instance{
+			ID:               0,
+			TotalInstances:   1,
+			Cmd:              cmd,
+			RequestChan:      make(chan *request, 1),
+			ResponseChan:     make(chan *response, 1),
+			TimeFromRequests: true,
+		}
+		go func() {
+			for range instance.RequestChan {
+			}
+		}()
+		if err := instance.Start(context.Background()); err != nil {
+			t.Fatalf("error starting an instance of tester: %v", err)
+		}
+		err := checkedWait(t, instance)
+		close(instance.RequestChan)
+		if err != nil {
+			t.Errorf("error running tester with input %q: %v", tc.input, err)
+			continue
+		}
+		if instance.TimeRunning < tc.minTime || (tc.maxTime > 0 && instance.TimeRunning > tc.maxTime) {
+			t.Errorf("wrong time of an instance with input %q: got %v, want between %v and %v", tc.input, instance.TimeRunning, tc.minTime, tc.maxTime)
+		}
 	}
 }
@@ -0,0 +1,83 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startInstance starts cmd in its own process group, so that killInstance can kill the
+// processes it has started, too.
+func startInstance(cmd *exec.Cmd, r *os.File, w *os.File, shm *os.File) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.ExtraFiles = []*os.File{r, w}
+	if shm != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, shm)
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", shmFdEnv, 3+len(cmd.ExtraFiles)-1))
+	}
+	return cmd.Start()
+}
+
+// killInstance kills the process group of a command started with startInstance.
+func killInstance(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// pauseInstance stops the process group of a command started with startInstance.
+func pauseInstance(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGSTOP)
+}
+
+// resumeInstance continues the process group of a command stopped with pauseInstance.
+func resumeInstance(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGCONT)
+}
+
+// stopHelperName is the name under which parunner reexecutes itself to start an instance
+// that is stopped before it executes its binary.
+const stopHelperName = "parunner-stop-helper"
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == stopHelperName {
+		err := stopHelperMain(os.Args[1:])
+		fmt.Fprintf(os.Stderr, "parunner stop helper: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// stopCommand modifies cmd so that the process stops itself with SIGSTOP before it executes
+// the command's binary, so that a debugger can attach to it before the binary runs any code.
+// The binary is executed in the same process once the process is continued.
+func stopCommand(cmd *exec.Cmd) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{stopHelperName, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// stopHelperMain runs in the helper process. The arguments are the path of the binary and
+// its arguments. It returns only on error.
+func stopHelperMain(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("expected at least 2 arguments, got %d", len(args))
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGSTOP); err != nil {
+		return os.NewSyscallError("kill", err)
+	}
+	return syscall.Exec(args[0], args[1:], os.Environ())
+}
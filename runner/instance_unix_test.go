@@ -0,0 +1,119 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInstanceStopAtStart(t *testing.T) {
+	cmd := exec.Command(testerPath)
+	// The instance computes after its last request, which has to be counted.
+	cmd.Stdin = strings.NewReader("Safoo\nC\n")
+	stdout, err := ioutil.TempFile("", "parunner-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stdout.Name())
+	defer stdout.Close()
+	cmd.Stdout = stdout
+	var pid int
+	instance := &Instance{
+		ID:             0,
+		TotalInstances: 1,
+		Cmd:            cmd,
+		RequestChan:    make(chan *request, 1),
+		ResponseChan:   make(chan *response, 1),
+		StopAtStart:    true,
+		Observer: ObserverFunc(func(e Event) {
+			if e.Type == InstanceStarted {
+				pid = e.PID
+			}
+		}),
+	}
+	if err := instance.Start(context.Background()); err != nil {
+		t.Fatalf("error starting an instance of tester: %v", err)
+	}
+	if pid != instance.Cmd.Process.Pid {
+		t.Errorf("wrong PID reported: got=%d, want=%d", pid, instance.Cmd.Process.Pid)
+	}
+	lastRequestChan := make(chan *request, 1)
+	go func() {
+		var last *request
+		for req := range instance.RequestChan {
+			last = req
+		}
+		lastRequestChan <- last
+	}()
+	waitChan := make(chan error)
+	go func() {
+		waitChan <- checkedWait(t, instance)
+	}()
+	select {
+	case err := <-waitChan:
+		close(instance.RequestChan)
+		t.Fatalf("a stopped instance has finished, err=%v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// The tester prints its ID as soon as it starts.
+	if fi, err := stdout.Stat(); err != nil || fi.Size() != 0 {
+		t.Errorf("a stopped instance has started running its binary (%v)", err)
+	}
+	instance.Cmd.Process.Signal(syscall.SIGCONT)
+	err = <-waitChan
+	close(instance.RequestChan)
+	if err != nil {
+		t.Fatalf("error running a continued instance: %v", err)
+	}
+	lastRequest := <-lastRequestChan
+	// The time the instance spent stopped isn't CPU time, so the measured time is right.
+	if lastRequest == nil || instance.TimeRunning < lastRequest.time+20*time.Millisecond {
+		t.Errorf("instance's time doesn't include the CPU time used after its last request (%+v): got %v", lastRequest, instance.TimeRunning)
+	}
+}
+
+func TestInstanceKillsProcessGroup(t *testing.T) {
+	for _, script := range []string{
+		// The instance exits, leaving behind a process that holds its stdout.
+		"sleep 100 &",
+		// The instance is killed while its child is running.
+		"sleep 100; true",
+	} {
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Stdout = ioutil.Discard
+		instance := &Instance{
+			ID:             0,
+			TotalInstances: 1,
+			Cmd:            cmd,
+			RequestChan:    make(chan *request, 1),
+			ResponseChan:   make(chan *response, 1),
+		}
+		if err := instance.Start(context.Background()); err != nil {
+			t.Fatalf("error starting an instance of %q: %v", script, err)
+		}
+		go func() {
+			for range instance.RequestChan {
+			}
+		}()
+		waitChan := make(chan error, 1)
+		go func() {
+			waitChan <- instance.Wait(context.Background())
+		}()
+		time.AfterFunc(100*time.Millisecond, func() { instance.Kill() })
+		select {
+		case <-waitChan:
+		case <-time.After(5 * time.Second):
+			t.Errorf("instance running %q didn't finish after being killed", script)
+			syscall.Kill(-instance.Cmd.Process.Pid, syscall.SIGKILL)
+			<-waitChan
+		}
+		close(instance.RequestChan)
+	}
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// The shared memory transport is not supported on Windows, so shm is always nil.
+func startInstance(cmd *exec.Cmd, r *os.File, w *os.File, shm *os.File) error {
+	var rHandle syscall.Handle
+	var wHandle syscall.Handle
+	p, _ := syscall.GetCurrentProcess()
+	if err := syscall.DuplicateHandle(p, syscall.Handle(r.Fd()), p, &rHandle, 0, true, syscall.DUPLICATE_SAME_ACCESS); err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(rHandle)
+	if err := syscall.DuplicateHandle(p, syscall.Handle(w.Fd()), p, &wHandle, 0, true, syscall.DUPLICATE_SAME_ACCESS); err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(wHandle)
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("ZSHANDLE_IN=%d", rHandle), fmt.Sprintf("ZSHANDLE_OUT=%d", wHandle))
+	return cmd.Start()
+}
+
+// killInstance kills the command. Unlike on Unix, the processes it has started are not killed.
+func killInstance(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func pauseInstance(cmd *exec.Cmd) error {
+	return errors.New("pausing instances is not supported on Windows")
+}
+
+func resumeInstance(cmd *exec.Cmd) error {
+	return errors.New("pausing instances is not supported on Windows")
+}
+
+func stopCommand(cmd *exec.Cmd) error {
+	return errors.New("stopping instances is not supported on Windows")
+}
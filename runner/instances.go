@@ -0,0 +1,240 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+// InstanceError is an error with an associated instance ID
+type InstanceError struct {
+	ID  int
+	Err error
+	// Termination describes how the instance has finished, if it has been started.
+	Termination *Termination
+}
+
+func (ie InstanceError) Error() string {
+	if ie.Termination != nil {
+		return fmt.Sprintf("Error of instance %d: %s: %v", ie.ID, ie.Termination.Verdict(), ie.Err)
+	}
+	return fmt.Sprintf("Error of instance %d: %v", ie.ID, ie.Err)
+}
+
+// ErrCanceled is returned by RunInstances when its context is done before the instances
+// finish. Instances are all the instances; their statistics cover the time until they were
+// killed.
+type ErrCanceled struct {
+	// Err is the error of the context, context.Canceled or context.DeadlineExceeded.
+	Err       error
+	Instances []*Instance
+}
+
+func (err ErrCanceled) Error() string {
+	return fmt.Sprintf("the run was stopped: %v", err.Err)
+}
+
+func (err ErrCanceled) Unwrap() error {
+	return err.Err
+}
+
+// RunInstances starts each command from opts.Commands (or each function
+// from opts.Funcs) in an Instance and
+// waits either for all of them to finish successfully or for
+// the first error. In the latter case, all the rest of
+// the instances are killed. All the instances are then returned
+// in the slice. RunInstances additionally guarantees the following:
+// * The instance slice is valid even if the error is non-nil, unless
+//   the error occurs before any instance is created
+// * All the commands have been started before RunInstances returns
+// * All the instanced have been waited on before RunInstances returns
+// * If the error encountered is associated with an instance,
+//   an instance of InstanceError is returned. That instance contains
+//   the instance ID of the instance that caused the error.
+// * If ctx is done, all the instances are killed and ErrCanceled
+//   is returned.
+// * All the output sinks from opts.Instances are closed before RunInstances
+//   returns. If closing one fails and there is no other error, that error is
+//   returned.
+func RunInstances(ctx context.Context, opts Options) (_ []*Instance, err error) {
+	cmds := opts.Commands
+	if len(opts.Funcs) > 0 {
+		if len(cmds) > 0 {
+			return nil, errors.New("both commands and functions given as instances")
+		}
+		// The commands only carry the outputs of the functions.
+		cmds = make([]*exec.Cmd, len(opts.Funcs))
+		for i := range cmds {
+			cmds[i] = &exec.Cmd{}
+		}
+	}
+	if opts.Instances != nil && len(opts.Instances) != len(cmds) {
+		return nil, fmt.Errorf("options of %d instances given for %d commands", len(opts.Instances), len(cmds))
+	}
+	instanceOpts := make([]InstanceOptions, len(cmds))
+	copy(instanceOpts, opts.Instances)
+	spillThreshold := opts.SpillThreshold
+	if spillThreshold == 0 {
+		spillThreshold = DefaultSpillThreshold
+	}
+
+	// The store must outlive all the instances, so it is closed after they're all waited for.
+	store := NewMessageStore(spillThreshold)
+	defer store.Close()
+	defer func() {
+		for _, o := range instanceOpts {
+			for _, sink := range []OutputSink{o.Stdout, o.Stderr} {
+				if sink == nil {
+					continue
+				}
+				if err1 := sink.Close(); err1 != nil {
+					if _, ok := err.(ErrRemainingMessages); err == nil || ok {
+						err = err1
+					}
+				}
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var scheduler *Scheduler
+	if opts.Parallelism > 0 && len(opts.Workers) == 0 {
+		scheduler = NewScheduler(opts.Parallelism)
+	}
+
+	results := make(chan error, 1)
+	// killReason is the reason for killing the instances that are still running when we return.
+	killReason := "another instance has failed"
+	is := make([]*Instance, len(cmds))
+	for i, cmd := range cmds {
+		o := instanceOpts[i]
+		if o.Stdout != nil {
+			cmd.Stdout = o.Stdout
+		}
+		if o.Stderr != nil {
+			cmd.Stderr = o.Stderr
+		}
+		is[i] = &Instance{
+			ID:                i,
+			TotalInstances:    len(cmds),
+			Cmd:               cmd,
+			RequestChan:       make(chan *request, 1),
+			ResponseChan:      make(chan *response, 1),
+			Store:             store,
+			Observer:          opts.Observer,
+			MessageCountLimit: opts.MessageCountLimit,
+			MessageSizeLimit:  opts.MessageSizeLimit,
+			TimeLimit:         opts.TimeLimit,
+			MemoryLimit:       opts.MemoryLimit,
+			SharedMemory:      opts.SharedMemory,
+			// A debugger couldn't attach to a sandboxed instance.
+			Sandbox:           opts.Sandbox && !o.Debugged,
+			TimeScale:         opts.TimeScale,
+			StopAtStart:       o.StopAtStart,
+			TimeFromRequests:  o.TimeFromRequests,
+			CPUs:              o.CPUs,
+			DetectToolReports: o.DetectToolReports,
+		}
+		if o.Speed != 0 {
+			if is[i].TimeScale == 0 {
+				is[i].TimeScale = 1
+			}
+			is[i].TimeScale /= o.Speed
+		}
+		if len(opts.Funcs) > 0 {
+			is[i].Func = opts.Funcs[i]
+		} else if len(opts.Workers) > 0 {
+			is[i].Worker = opts.Workers[i%len(opts.Workers)]
+			is[i].WorkerToken = opts.WorkerToken
+		}
+		// The debugged instance is stopped and continued by the user instead.
+		if !o.Debugged {
+			is[i].Scheduler = scheduler
+		}
+		if err := is[i].Start(ctx); err != nil {
+			select {
+			case results <- InstanceError{ID: i, Err: err}:
+			default:
+			}
+			close(is[i].RequestChan)
+			continue
+		}
+		defer func(instance *Instance) {
+			instance.killBecause(killReason)
+		}(is[i])
+		wg.Add(1)
+		go func(i int, instance *Instance) {
+			err := instance.Wait(ctx)
+			if err != nil && err == ctx.Err() {
+				// The instance was killed because the run is being canceled.
+				select {
+				case results <- err:
+				default:
+				}
+			} else if err != nil {
+				select {
+				case results <- InstanceError{ID: i, Err: err, Termination: &instance.Termination}:
+				default:
+				}
+			}
+			// The instance leaves the communication channels open. We close the RequestChan
+			// to signal the message router that this instance has finished. In case of an error,
+			// we need to do this after possibly storing the error, so that message router's error
+			// (e.g. ErrDeadlock due to the last nonblocked instance exising) doesn't override ours.
+			close(instance.RequestChan)
+			wg.Done()
+		}(i, is[i])
+	}
+	wg.Add(1)
+	go func() {
+		requestChans := make([]<-chan *request, len(is))
+		for i := range requestChans {
+			requestChans[i] = is[i].RequestChan
+		}
+		responseChans := make([]chan<- *response, len(is))
+		for i := range responseChans {
+			responseChans[i] = is[i].ResponseChan
+		}
+		defer func() {
+			for _, ch := range responseChans {
+				close(ch)
+			}
+		}()
+		err := RouteMessages(ctx, requestChans, responseChans, RouteOptions{Observer: opts.Observer, Chooser: opts.Chooser, Adversary: opts.Adversary})
+		if err != nil {
+			select {
+			case results <- err:
+			default:
+			}
+		}
+		wg.Done()
+	}()
+	go func() {
+		wg.Wait()
+		select {
+		case results <- nil:
+		default:
+		}
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			select {
+			case results <- ctx.Err():
+			default:
+			}
+		case <-done:
+		}
+	}()
+	err = <-results
+	if err != nil && err == ctx.Err() {
+		killReason = err.Error()
+		err = ErrCanceled{Err: err, Instances: is}
+	}
+	return is, err
+}
@@ -1,12 +1,14 @@
-package main
+package runner
 
 import (
 	"bytes"
-	"io/ioutil"
+	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestInstances(t *testing.T) {
@@ -29,6 +31,9 @@ func TestInstances(t *testing.T) {
 		{"deadlock", []string{"Scfoo\nR*\n", "R*\n", ""}, nil, nil, true},
 		{"fail and hang", []string{"H\n", "Q 1\n"}, nil, []int{1}, false},
 		{"fail and hanging recv", []string{"R*\n", "Q 1\n"}, nil, []int{1}, false},
+		{"timed sends", []string{"T0\nR*\nR*\n", "T5\nSafoo\n", "T1\nSabar\n"}, []string{"0 3\n2 3 bar\n1 3 foo\n", "1 3\n", "2 3\n"}, nil, false},
+		{"partial writes", []string{"PRb\n", "PSafoo\n"}, []string{"0 2\n1 3 foo\n", "1 2\n"}, nil, false},
+		{"exit mid-send", []string{"R*\n", "ESafoo\n"}, nil, nil, true},
 	}
 	for _, tc := range testcases {
 		outputs := make([]bytes.Buffer, len(tc.inputs))
@@ -38,7 +43,7 @@ func TestInstances(t *testing.T) {
 			cmds[i].Stdin = strings.NewReader(input)
 			cmds[i].Stdout = &outputs[i]
 		}
-		_, err := RunInstances(cmds, ioutil.Discard)
+		_, err := RunInstances(context.Background(), Options{Commands: cmds})
 		if _, ok := err.(ErrRemainingMessages); ok {
 			err = nil
 		}
@@ -78,11 +83,35 @@ func TestInstances(t *testing.T) {
 
 func TestInstancesStartError(t *testing.T) {
 	cmds := []*exec.Cmd{exec.Command("/does/not/exist")}
-	_, err := RunInstances(cmds, ioutil.Discard)
+	_, err := RunInstances(context.Background(), Options{Commands: cmds})
 	if err == nil {
 		t.Errorf("expected an error when trying to run a nonexistent binary")
 	}
 }
 
+func TestInstancesCanceled(t *testing.T) {
+	cmds := []*exec.Cmd{exec.Command(testerPath), exec.Command(testerPath)}
+	cmds[0].Stdin = strings.NewReader("Sbfoo\nH\n")
+	cmds[1].Stdin = strings.NewReader("Ra\nR*\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	is, err := RunInstances(ctx, Options{Commands: cmds})
+	if len(is) != len(cmds) {
+		t.Fatalf("wrong number of instances returned: got=%d, want=%d", len(is), len(cmds))
+	}
+	ec, ok := err.(ErrCanceled)
+	if !ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wrong error from a canceled RunInstances: got=%v, want ErrCanceled with %v", err, context.DeadlineExceeded)
+	}
+	for i, instance := range ec.Instances {
+		if got, want := instance.Termination.String(), "killed (context deadline exceeded)"; got != want {
+			t.Errorf("wrong termination of instance %d: got=%q, want=%q", i, got, want)
+		}
+	}
+	if is[0].MessagesSent != 1 {
+		t.Errorf("wrong number of messages sent by a canceled instance: got=%d, want=1", is[0].MessagesSent)
+	}
+}
+
 // TODO: check what happens when we send/recv message to/from an instance that doesn't exist
 // TODO: check what happens when an instance claims that its CPU time goes backward
@@ -0,0 +1,14 @@
+package runner
+
+import "fmt"
+
+// ErrMemoryLimit is returned when an instance is killed for exceeding its memory limit.
+// It is usually encapsulated in an InstanceError that specifies the instance ID.
+type ErrMemoryLimit struct {
+	Limit int64
+	Err   error
+}
+
+func (err ErrMemoryLimit) Error() string {
+	return fmt.Sprintf("memory limit (%d bytes) exceeded", err.Limit)
+}
@@ -0,0 +1,222 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// A memoryCgroup is a cgroup that limits the memory of a single instance. It is created
+// under the cgroup of this process, in the v1 memory hierarchy if there is one, or else in
+// the unified (v2) hierarchy.
+type memoryCgroup struct {
+	dir string
+	v2  bool
+	// fd is an open descriptor of dir, used to start the process in the cgroup (v2 only).
+	fd *os.File
+}
+
+// cgroupCount makes the names of the cgroups created by this process unique.
+var cgroupCount int64
+
+var memoryBase struct {
+	once sync.Once
+	dir  string
+	v2   bool
+	err  error
+}
+
+// newMemoryCgroup creates a cgroup that limits the memory of its processes to limit bytes.
+func newMemoryCgroup(limit int64) (*memoryCgroup, error) {
+	memoryBase.once.Do(func() {
+		memoryBase.dir, memoryBase.v2, memoryBase.err = findMemoryCgroup()
+	})
+	if memoryBase.err != nil {
+		return nil, fmt.Errorf("cannot limit the memory of instances: %v", memoryBase.err)
+	}
+	c := &memoryCgroup{
+		dir: filepath.Join(memoryBase.dir, fmt.Sprintf("parunner-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupCount, 1))),
+		v2:  memoryBase.v2,
+	}
+	if err := os.Mkdir(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot limit the memory of instances: %v", err)
+	}
+	limitFile, swapFile, swapLimit := "memory.limit_in_bytes", "memory.memsw.limit_in_bytes", limit
+	if c.v2 {
+		limitFile, swapFile, swapLimit = "memory.max", "memory.swap.max", 0
+	}
+	if err := writeCgroupFile(c.dir, limitFile, limit); err != nil {
+		c.remove()
+		return nil, err
+	}
+	// The instance shouldn't get around the limit by swapping. The swap limit is missing
+	// if the kernel doesn't account swap, in which case there is nothing to do.
+	writeCgroupFile(c.dir, swapFile, swapLimit)
+	if c.v2 {
+		var err error
+		if c.fd, err = os.Open(c.dir); err != nil {
+			c.remove()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// prepare makes cmd start in the cgroup, if the hierarchy allows that.
+func (c *memoryCgroup) prepare(cmd *exec.Cmd) {
+	if !c.v2 {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.fd.Fd())
+}
+
+// add moves a process started with a command given to prepare to the cgroup, unless it
+// was started in it. A process can't be started in a cgroup of the v1 hierarchy, so the
+// memory it allocates before it is moved isn't limited.
+func (c *memoryCgroup) add(pid int) error {
+	if c.v2 {
+		return nil
+	}
+	return writeCgroupFile(c.dir, "cgroup.procs", int64(pid))
+}
+
+// oomKilled returns true if a process in the cgroup was killed for exceeding the limit.
+func (c *memoryCgroup) oomKilled() bool {
+	file := "memory.oom_control"
+	if c.v2 {
+		file = "memory.events"
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, file))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return fields[1] != "0"
+		}
+	}
+	return false
+}
+
+// remove removes the cgroup. It fails if some processes are still in it.
+func (c *memoryCgroup) remove() {
+	if c.fd != nil {
+		c.fd.Close()
+	}
+	os.Remove(c.dir)
+}
+
+func writeCgroupFile(dir, name string, value int64) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(strconv.FormatInt(value, 10)), 0644)
+}
+
+// findMemoryCgroup returns the directory of the cgroup of this process in which cgroups
+// with memory limits can be created, and whether it is in the unified hierarchy.
+func findMemoryCgroup() (string, bool, error) {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", false, err
+	}
+	// See cgroups(7) for the format of /proc/self/cgroup. The unified hierarchy has an
+	// empty list of controllers.
+	var v1Path, v2Path string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			v2Path = parts[2]
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller == "memory" {
+				v1Path = parts[2]
+			}
+		}
+	}
+	mounts, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", false, err
+	}
+	var v2Dir string
+	for _, line := range strings.Split(string(mounts), "\n") {
+		// See proc(5) for the format of mountinfo. The filesystem type and the superblock
+		// options follow a separator after the optional fields.
+		fields := strings.Fields(line)
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+3 >= len(fields) {
+			continue
+		}
+		root, mountPoint, fsType, options := fields[3], fields[4], fields[sep+1], fields[sep+3]
+		mountPoint, err := unescapeMountPoint(mountPoint)
+		if err != nil {
+			return "", false, err
+		}
+		dir := func(path string) string {
+			if root != "/" {
+				path = strings.TrimPrefix(path, root)
+			}
+			return filepath.Join(mountPoint, path)
+		}
+		switch {
+		case fsType == "cgroup" && v1Path != "":
+			for _, opt := range strings.Split(options, ",") {
+				if opt == "memory" {
+					return dir(v1Path), false, nil
+				}
+			}
+		case fsType == "cgroup2" && v2Path != "" && v2Dir == "":
+			v2Dir = dir(v2Path)
+		}
+	}
+	if v2Dir == "" {
+		return "", false, errors.New("no cgroup hierarchy with the memory controller is mounted")
+	}
+	return v2Dir, true, enableMemoryController(v2Dir)
+}
+
+// enableMemoryController enables the memory controller for the children of dir, a cgroup
+// of the unified hierarchy. A cgroup with controllers enabled for its children can't have
+// processes of its own, so this process is moved to a new child cgroup if necessary.
+func enableMemoryController(dir string) error {
+	controllers, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(" "+strings.TrimSpace(string(controllers))+" ", " memory ") {
+		return fmt.Errorf("the memory controller is not available in %s", dir)
+	}
+	enable := func() error {
+		return ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+memory"), 0644)
+	}
+	if enable() == nil {
+		return nil
+	}
+	leaf := filepath.Join(dir, "parunner")
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", int64(os.Getpid())); err != nil {
+		return err
+	}
+	return enable()
+}
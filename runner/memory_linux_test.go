@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// skipWithoutMemoryCgroup skips the test if cgroups with memory limits can't be created.
+func skipWithoutMemoryCgroup(t *testing.T) {
+	c, err := newMemoryCgroup(1 << 30)
+	if err != nil {
+		t.Skipf("memory limits not available: %v", err)
+	}
+	c.remove()
+}
+
+func TestTerminationMemoryLimit(t *testing.T) {
+	skipWithoutMemoryCgroup(t)
+	for _, tc := range []struct {
+		input   string
+		verdict string
+	}{
+		{"M4\n", "OK"},
+		{"M256\nH\n", "MLE"},
+	} {
+		cmd := exec.Command(testerPath)
+		cmd.Stdin = strings.NewReader(tc.input)
+		cmd.Stdout = ioutil.Discard
+		is, err := RunInstances(context.Background(), Options{Commands: []*exec.Cmd{cmd}, MemoryLimit: 64 << 20})
+		if got := is[0].Termination.Verdict(); got != tc.verdict {
+			t.Errorf("wrong verdict for %q: got=%q, want=%q (%v)", tc.input, got, tc.verdict, err)
+		}
+		if tc.verdict == "MLE" {
+			if ie, ok := err.(InstanceError); !ok {
+				t.Errorf("expected an InstanceError, got %v", err)
+			} else if _, ok := ie.Err.(ErrMemoryLimit); !ok {
+				t.Errorf("expected ErrMemoryLimit, got %v", ie.Err)
+			}
+		}
+	}
+	left, _ := filepath.Glob(filepath.Join(memoryBase.dir, fmt.Sprintf("parunner-%d-*", os.Getpid())))
+	if len(left) > 0 {
+		t.Errorf("cgroups left behind: %v", left)
+	}
+}
@@ -0,0 +1,19 @@
+// +build !linux
+
+package runner
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type memoryCgroup struct{}
+
+func newMemoryCgroup(limit int64) (*memoryCgroup, error) {
+	return nil, errors.New("limiting the memory of instances is not supported on this platform")
+}
+
+func (c *memoryCgroup) prepare(cmd *exec.Cmd) {}
+func (c *memoryCgroup) add(pid int) error     { return nil }
+func (c *memoryCgroup) oomKilled() bool       { return false }
+func (c *memoryCgroup) remove()               {}
@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Channels multiplexed over a connection between a coordinator and a worker.
+const (
+	// Job description (to the worker) and exit status (from the worker).
+	chanControl = iota
+	// Responses to the instance (to the worker) and requests from it (from the worker).
+	chanComm
+	// Standard input (to the worker) and standard output (from the worker).
+	chanStdio
+	// Standard error (from the worker).
+	chanStderr
+	// Kill requests (to the worker).
+	chanKill
+	numChans
+)
+
+// maxFrameSize limits the size of a single frame, so that a corrupted stream doesn't cause a huge allocation.
+const maxFrameSize = 1 << 20
+
+// A mux multiplexes a number of unidirectional byte streams in each direction over a
+// single connection. Each frame carries a channel number, a length and data. A frame
+// of length zero marks the end of its channel's stream.
+type mux struct {
+	conn io.ReadWriteCloser
+
+	wmu sync.Mutex
+
+	readers [numChans]*io.PipeReader
+	writers [numChans]*io.PipeWriter
+}
+
+func newMux(conn io.ReadWriteCloser) *mux {
+	m := &mux{conn: conn}
+	for i := range m.readers {
+		m.readers[i], m.writers[i] = io.Pipe()
+	}
+	go m.demux()
+	return m
+}
+
+func (m *mux) demux() {
+	err := func() error {
+		for {
+			var hdr struct {
+				Chan   byte
+				Length uint32
+			}
+			if err := binary.Read(m.conn, binary.LittleEndian, &hdr); err != nil {
+				return err
+			}
+			if int(hdr.Chan) >= numChans || hdr.Length > maxFrameSize {
+				return fmt.Errorf("invalid frame header: channel %d, length %d", hdr.Chan, hdr.Length)
+			}
+			if hdr.Length == 0 {
+				m.writers[hdr.Chan].Close()
+				continue
+			}
+			// A write to a closed pipe fails, but we still need to skip the frame's data.
+			if _, err := io.CopyN(ignoreClosed{m.writers[hdr.Chan]}, m.conn, int64(hdr.Length)); err != nil {
+				return err
+			}
+		}
+	}()
+	if err == io.EOF {
+		err = errors.New("connection closed")
+	}
+	for _, w := range m.writers {
+		w.CloseWithError(err)
+	}
+}
+
+type ignoreClosed struct {
+	w io.Writer
+}
+
+func (ic ignoreClosed) Write(buf []byte) (int, error) {
+	if _, err := ic.w.Write(buf); err != nil && err != io.ErrClosedPipe {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (m *mux) writeFrame(ch int, buf []byte) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	hdr := struct {
+		Chan   byte
+		Length uint32
+	}{byte(ch), uint32(len(buf))}
+	if err := binary.Write(m.conn, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	_, err := m.conn.Write(buf)
+	return err
+}
+
+// Reader returns the stream of data received on a channel.
+func (m *mux) Reader(ch int) io.ReadCloser {
+	return m.readers[ch]
+}
+
+// Writer returns a writer that sends data on a channel. Closing it marks the end of the
+// channel's stream.
+func (m *mux) Writer(ch int) io.WriteCloser {
+	return &muxWriter{m: m, ch: ch}
+}
+
+// Close closes the underlying connection.
+func (m *mux) Close() error {
+	return m.conn.Close()
+}
+
+type muxWriter struct {
+	m  *mux
+	ch int
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (mw *muxWriter) Write(buf []byte) (int, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	written := 0
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		if err := mw.m.writeFrame(mw.ch, buf[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+func (mw *muxWriter) Close() error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.closed {
+		return nil
+	}
+	mw.closed = true
+	return mw.m.writeFrame(mw.ch, nil)
+}
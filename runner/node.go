@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/robryk/parunner/wire"
+)
+
+// A NodeFunc is the program of an instance that runs as a goroutine of this process
+// instead of as a separate process. The instance finishes when the function returns and
+// fails if it panics.
+type NodeFunc func(ctx NodeContext)
+
+// A NodeContext lets a NodeFunc communicate with the other instances. Its methods must
+// only be called by the goroutine that runs the NodeFunc. Once the instance is killed,
+// they end the goroutine with runtime.Goexit, so a NodeFunc that computes for a long time
+// without calling them can't be killed.
+//
+// The instance's CPU time is measured by a virtual clock that only advances when Compute
+// is called. The times passed to the other instances have millisecond resolution, like
+// those reported by zeus_local.c.
+type NodeContext interface {
+	// NodeID returns the ID of this instance.
+	NodeID() int
+	// NumberOfNodes returns the number of instances.
+	NumberOfNodes() int
+	// Send sends a message to the instance with the given ID.
+	Send(target int, message []byte)
+	// Receive waits for a message from the instance with the given ID, or from any
+	// instance if source is -1, and returns it together with its sender.
+	Receive(source int) (sender int, message []byte)
+	// Compute advances the virtual clock of the instance by d, as if it has computed for
+	// that long.
+	Compute(d time.Duration)
+	// Time returns the reading of the virtual clock.
+	Time() time.Duration
+	// Stdout and Stderr return the output streams of the instance.
+	Stdout() io.Writer
+	Stderr() io.Writer
+}
+
+// errNodeKilled is the error of a killed in-process instance.
+var errNodeKilled = errors.New("in-process instance killed")
+
+// ErrNodePanic is returned when the NodeFunc of an instance panics.
+// It is usually encapsulated in an InstanceError that specifies the instance ID.
+type ErrNodePanic struct {
+	Value interface{}
+	// Stack is the stack trace of the goroutine that has panicked.
+	Stack []byte
+}
+
+func (err ErrNodePanic) Error() string {
+	return fmt.Sprintf("panic: %v", err.Value)
+}
+
+// funcProcess runs a NodeFunc in a goroutine. It talks to the instance over a pair of
+// in-memory pipes, using the same protocol as zeus_local.c.
+type funcProcess struct {
+	f              NodeFunc
+	stdout, stderr io.Writer
+
+	// The NodeFunc's ends of the pipes.
+	reqw  *io.PipeWriter
+	respr *io.PipeReader
+
+	d      *wire.Decoder
+	e      *wire.Encoder
+	header *wire.Header
+	clock  time.Duration
+
+	killOnce sync.Once
+	killed   chan bool
+	done     chan bool
+	err      error
+}
+
+func newFuncProcess(f NodeFunc, stdout, stderr io.Writer) *funcProcess {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	return &funcProcess{f: f, stdout: stdout, stderr: stderr, killed: make(chan bool), done: make(chan bool)}
+}
+
+func (p *funcProcess) Start() (io.ReadCloser, io.WriteCloser, error) {
+	reqr, reqw := io.Pipe()
+	respr, respw := io.Pipe()
+	p.reqw, p.respr = reqw, respr
+	p.d = wire.NewDecoder(respr)
+	p.e = wire.NewEncoder(reqw)
+	go p.run()
+	return reqr, respw, nil
+}
+
+func (p *funcProcess) run() {
+	defer close(p.done)
+	defer func() {
+		if r := recover(); r != nil {
+			p.err = ErrNodePanic{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	var err error
+	if p.header, err = p.d.ReadHeader(); err != nil {
+		p.fail(err)
+	}
+	p.f(p)
+}
+
+// fail ends the NodeFunc's goroutine after a failed communication.
+func (p *funcProcess) fail(err error) {
+	select {
+	case <-p.killed:
+		err = errNodeKilled
+	default:
+	}
+	p.err = err
+	runtime.Goexit()
+}
+
+// checkKilled ends the NodeFunc's goroutine if the instance was killed.
+func (p *funcProcess) checkKilled() {
+	select {
+	case <-p.killed:
+		p.fail(errNodeKilled)
+	default:
+	}
+}
+
+func (p *funcProcess) NodeID() int {
+	return p.header.NodeID
+}
+
+func (p *funcProcess) NumberOfNodes() int {
+	return p.header.NodeCount
+}
+
+func (p *funcProcess) Send(target int, message []byte) {
+	p.checkKilled()
+	if err := p.e.WriteRequest(&wire.Request{Op: wire.OpSend, Peer: target, Time: p.clock, Payload: message}); err != nil {
+		p.fail(err)
+	}
+}
+
+func (p *funcProcess) Receive(source int) (int, []byte) {
+	p.checkKilled()
+	if err := p.e.WriteRequest(&wire.Request{Op: wire.OpRecv, Peer: source, Time: p.clock}); err != nil {
+		p.fail(err)
+	}
+	resp, err := p.d.ReadResponse()
+	if err != nil {
+		p.fail(err)
+	}
+	return resp.Source, resp.Payload
+}
+
+func (p *funcProcess) Compute(d time.Duration) {
+	p.clock += d
+}
+
+func (p *funcProcess) Time() time.Duration {
+	return p.clock
+}
+
+func (p *funcProcess) Stdout() io.Writer {
+	return p.stdout
+}
+
+func (p *funcProcess) Stderr() io.Writer {
+	return p.stderr
+}
+
+func (p *funcProcess) Wait() (time.Duration, error) {
+	<-p.done
+	return p.clock, p.err
+}
+
+func (p *funcProcess) Close() {
+	p.reqw.Close()
+	p.respr.Close()
+}
+
+func (p *funcProcess) Kill() error {
+	p.killOnce.Do(func() {
+		close(p.killed)
+		// A receive that is in progress fails. The requests that are being sent are still
+		// read by the instance, so sends don't need to be interrupted.
+		p.respr.CloseWithError(errNodeKilled)
+	})
+	return nil
+}
@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func runNodes(ctx context.Context, fs ...NodeFunc) ([]*Instance, error) {
+	is, err := RunInstances(ctx, Options{Funcs: fs})
+	if _, ok := err.(ErrRemainingMessages); ok {
+		err = nil
+	}
+	return is, err
+}
+
+func TestNodesRing(t *testing.T) {
+	const n = 5
+	got := make([]string, n)
+	fs := make([]NodeFunc, n)
+	for i := range fs {
+		fs[i] = func(ctx NodeContext) {
+			id, count := ctx.NodeID(), ctx.NumberOfNodes()
+			ctx.Send((id+1)%count, []byte(fmt.Sprint(id)))
+			_, msg := ctx.Receive((id + count - 1) % count)
+			got[id] = string(msg)
+		}
+	}
+	if _, err := runNodes(context.Background(), fs...); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if want := []string{"4", "0", "1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong messages received: got=%q, want=%q", got, want)
+	}
+}
+
+func TestNodesReceiveAny(t *testing.T) {
+	var senders []int
+	receiver := func(ctx NodeContext) {
+		for i := 1; i < ctx.NumberOfNodes(); i++ {
+			sender, msg := ctx.Receive(-1)
+			if string(msg) != fmt.Sprint(sender) {
+				t.Errorf("wrong message from instance %d: %q", sender, msg)
+			}
+			senders = append(senders, sender)
+		}
+	}
+	sender := func(ctx NodeContext) {
+		// Later instances send earlier in simulated time.
+		ctx.Compute(time.Duration(ctx.NumberOfNodes()-ctx.NodeID()) * time.Millisecond)
+		ctx.Send(0, []byte(fmt.Sprint(ctx.NodeID())))
+	}
+	if _, err := runNodes(context.Background(), receiver, sender, sender, sender); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if want := []int{3, 2, 1}; !reflect.DeepEqual(senders, want) {
+		t.Errorf("messages received in wrong order: got=%v, want=%v", senders, want)
+	}
+}
+
+func TestNodesVirtualClock(t *testing.T) {
+	is, err := runNodes(context.Background(), func(ctx NodeContext) {
+		ctx.Compute(time.Second)
+		ctx.Send(1, nil)
+	}, func(ctx NodeContext) {
+		ctx.Compute(100 * time.Millisecond)
+		ctx.Receive(0)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if got, want := is[0].TimeRunning, time.Second; got != want {
+		t.Errorf("wrong running time of the sender: got=%v, want=%v", got, want)
+	}
+	if got, want := is[1].TimeBlocked, 900*time.Millisecond; got != want {
+		t.Errorf("wrong blocked time of the receiver: got=%v, want=%v", got, want)
+	}
+}
+
+func TestNodesDeadlock(t *testing.T) {
+	recv := func(ctx NodeContext) {
+		ctx.Receive(1 - ctx.NodeID())
+	}
+	if _, err := runNodes(context.Background(), recv, recv); err == nil {
+		t.Errorf("expected a deadlock")
+	} else if _, ok := err.(ErrDeadlock); !ok {
+		t.Errorf("expected a deadlock, got %v", err)
+	}
+}
+
+func TestNodesPanic(t *testing.T) {
+	_, err := runNodes(context.Background(), func(ctx NodeContext) {
+		ctx.Receive(1)
+	}, func(ctx NodeContext) {
+		panic("foo")
+	})
+	ie, ok := err.(InstanceError)
+	if !ok {
+		t.Fatalf("expected an InstanceError, got %v", err)
+	}
+	if ie.ID != 1 {
+		t.Errorf("wrong instance failed: got=%d, want=1", ie.ID)
+	}
+	if p, ok := ie.Err.(ErrNodePanic); !ok || p.Value != "foo" {
+		t.Errorf("expected a panic with value foo, got %v", ie.Err)
+	}
+}
+
+func TestNodesCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	is, err := runNodes(ctx, func(ctx NodeContext) {
+		ctx.Receive(1)
+	}, func(ctx NodeContext) {
+		// The instance can only be killed when it communicates.
+		for {
+			ctx.Compute(time.Millisecond)
+			ctx.Send(1, []byte("foo"))
+		}
+	})
+	if _, ok := err.(ErrCanceled); !ok {
+		t.Fatalf("expected ErrCanceled, got %v", err)
+	}
+	for i, instance := range is {
+		if instance.Termination.Killed == "" {
+			t.Errorf("instance %d wasn't killed: %v", i, instance.Termination.Verdict())
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// An EventType is the kind of an Event.
+type EventType int
+
+const (
+	// InstanceStarted is reported once the instance's process has started.
+	InstanceStarted EventType = iota
+	// InstanceFinished is reported once the instance has finished and its Termination is
+	// known.
+	InstanceFinished
+	// MessageSent is reported when a message is put in the receiver's queue. Instance is
+	// the sender and Peer is the receiver.
+	MessageSent
+	// ReceiveBlocked is reported when an instance starts to wait for a message. Peer is the
+	// instance it waits for, or -1 if it waits for any instance. The instance stays blocked
+	// until MessageDelivered is reported for it, which may happen right away.
+	ReceiveBlocked
+	// MessageDelivered is reported when a message is given to the instance that has waited
+	// for it. Instance is the receiver and Peer is the sender.
+	MessageDelivered
+	// Deadlock is reported when all the instances that are still alive wait for messages
+	// that will never arrive. Waiting lists them and Instance is -1.
+	Deadlock
+)
+
+var eventTypeNames = []string{"InstanceStarted", "InstanceFinished", "MessageSent", "ReceiveBlocked", "MessageDelivered", "Deadlock"}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+	return eventTypeNames[t]
+}
+
+// An Event is something that has happened to an instance or to a message during a run.
+type Event struct {
+	Type EventType
+	// Instance is the ID of the instance that the event concerns.
+	Instance int
+	// Peer is the ID of the other instance that takes part in the event, if there is one.
+	Peer int
+	// Time is the simulated time of the event. For MessageDelivered it is the time at
+	// which the receiver resumes, i.e. the later of the send and receive times.
+	Time time.Duration
+	// Size is the size of the message's payload, in bytes.
+	Size int
+	// PID is the process ID of the instance, for InstanceStarted of an instance run as a
+	// local process.
+	PID int
+	// Termination describes how the instance has finished, for InstanceFinished.
+	Termination *Termination
+	// Waiting are the instances that wait for messages, for Deadlock.
+	Waiting []int
+}
+
+// An Observer is notified of the events of a run. Observe is called by the goroutines that
+// run the instances and route the messages, possibly concurrently, so it should be
+// goroutine-safe and return quickly.
+//
+// InstanceStarted is reported before the other events of the instance. The events of the
+// messages are reported by the router in the order of their simulated times, which can
+// be after the instance that has sent the message has finished.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc is an adapter to allow the use of ordinary functions as Observers.
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// observe passes e on to o, if o is non-nil.
+func observe(o Observer, e Event) {
+	if o != nil {
+		o.Observe(e)
+	}
+}
+
+type commLog struct {
+	logger *log.Logger
+}
+
+// NewCommLog creates an Observer that writes a trace of the messages exchanged by the
+// instances to w.
+func NewCommLog(w io.Writer) Observer {
+	return commLog{log.New(w, "", 0)}
+}
+
+func (cl commLog) Observe(e Event) {
+	const logPrefix = "COMM: instancja %2d:"
+	switch e.Type {
+	case MessageSent:
+		cl.logger.Printf(logPrefix+"instancja %d wysyła do mnie wiadomość (%d bajtów) [%v]", e.Peer, e.Instance, e.Size, e.Time)
+	case ReceiveBlocked:
+		if e.Peer == -1 {
+			cl.logger.Printf(logPrefix+"czekam na wiadomość od dowolnej instancji [%v]", e.Instance, e.Time)
+		} else {
+			cl.logger.Printf(logPrefix+"czekam na wiadomość od instancji %d [%v]", e.Instance, e.Peer, e.Time)
+		}
+	case MessageDelivered:
+		cl.logger.Printf(logPrefix+"odebrałam wiadomość od instancji %d (%d bajtów)", e.Instance, e.Peer, e.Size)
+	}
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// eventRecorder is an Observer that remembers the events of each instance.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events map[int][]string
+}
+
+func (er *eventRecorder) Observe(e Event) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	if er.events == nil {
+		er.events = make(map[int][]string)
+	}
+	var s string
+	switch e.Type {
+	case InstanceFinished:
+		s = fmt.Sprintf("%v %v", e.Type, e.Termination.Verdict())
+	case Deadlock:
+		s = fmt.Sprintf("%v %v", e.Type, e.Waiting)
+	default:
+		s = fmt.Sprintf("%v %d %d", e.Type, e.Peer, e.Size)
+	}
+	er.events[e.Instance] = append(er.events[e.Instance], s)
+}
+
+func runObserved(t *testing.T, inputs ...string) (map[int][]string, error) {
+	cmds := make([]*exec.Cmd, len(inputs))
+	for i, input := range inputs {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+	}
+	er := &eventRecorder{}
+	_, err := RunInstances(context.Background(), Options{Commands: cmds, Observer: er})
+	return er.events, err
+}
+
+func TestInstancesObserver(t *testing.T) {
+	events, err := runObserved(t, "Sbfoo\n", "Ra\n")
+	if err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	// The router may report the send after the sender has finished.
+	if len(events[0]) == 3 && events[0][1] == "InstanceFinished OK" {
+		events[0][1], events[0][2] = events[0][2], events[0][1]
+	}
+	want := map[int][]string{
+		0: {"InstanceStarted 0 0", "MessageSent 1 3", "InstanceFinished OK"},
+		1: {"InstanceStarted 0 0", "ReceiveBlocked 0 0", "MessageDelivered 0 3", "InstanceFinished OK"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("wrong events: got=%v, want=%v", events, want)
+	}
+
+	events, err = runObserved(t, "Rb\n", "R*\n")
+	if _, ok := err.(ErrDeadlock); !ok {
+		t.Fatalf("expected a deadlock, got %v", err)
+	}
+	if got, want := events[-1], []string{"Deadlock [0 1]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong deadlock events: got=%q, want=%q", got, want)
+	}
+}
+
+func TestCommLog(t *testing.T) {
+	var buf bytes.Buffer
+	cl := NewCommLog(&buf)
+	for _, e := range []Event{
+		{Type: InstanceStarted, Instance: 1},
+		{Type: MessageSent, Instance: 0, Peer: 1, Size: 3, Time: 5},
+		{Type: ReceiveBlocked, Instance: 1, Peer: 0, Time: 2},
+		{Type: ReceiveBlocked, Instance: 1, Peer: -1, Time: 7},
+		{Type: MessageDelivered, Instance: 1, Peer: 0, Size: 3, Time: 5},
+	} {
+		cl.Observe(e)
+	}
+	want := "COMM: instancja  1:instancja 0 wysyła do mnie wiadomość (3 bajtów) [5ns]\n" +
+		"COMM: instancja  1:czekam na wiadomość od instancji 0 [2ns]\n" +
+		"COMM: instancja  1:czekam na wiadomość od dowolnej instancji [7ns]\n" +
+		"COMM: instancja  1:odebrałam wiadomość od instancji 0 (3 bajtów)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("wrong log: got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,287 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An OutputSink receives one output stream (stdout or stderr) of an instance. Close is
+// called once the instance has finished and flushes whatever the sink has buffered.
+type OutputSink interface {
+	io.Writer
+	Close() error
+}
+
+// A ClockedSink is a sink that needs to know the simulated time of the instance whose
+// output it receives. Instance.Start gives it the instance's clock.
+type ClockedSink interface {
+	OutputSink
+	SetClock(clock func() time.Duration)
+}
+
+type passthroughSink struct {
+	io.Writer
+}
+
+func (passthroughSink) Close() error {
+	return nil
+}
+
+// NewPassthroughSink creates a sink that writes the output to w unchanged.
+func NewPassthroughSink(w io.Writer) OutputSink {
+	return passthroughSink{w}
+}
+
+type tagSink struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// NewTagSink creates a sink that writes the output to w line by line, prefixing each line
+// with tag. An unterminated last line is terminated when the sink is closed.
+func NewTagSink(tag string, w io.Writer) OutputSink {
+	pr, pw := io.Pipe()
+	ts := &tagSink{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := TagStream(tag, w, pr)
+		// Further writes fail with the error instead of blocking forever.
+		pr.CloseWithError(err)
+		ts.done <- err
+	}()
+	return ts
+}
+
+func (ts *tagSink) Write(buf []byte) (int, error) {
+	return ts.pw.Write(buf)
+}
+
+func (ts *tagSink) Close() error {
+	ts.pw.Close()
+	return <-ts.done
+}
+
+// NewFileSink creates a sink that writes the output to a newly created file.
+func NewFileSink(filename string) (OutputSink, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// BufferSink is a sink that keeps the output in memory. If Output is non-nil, the output
+// is written to it in one piece when the sink is closed, so that it doesn't interleave
+// with the outputs of other instances.
+type BufferSink struct {
+	Output io.Writer
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (bs *BufferSink) Write(buf []byte) (int, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.buf.Write(buf)
+}
+
+func (bs *BufferSink) Close() error {
+	if bs.Output == nil {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	_, err := bs.Output.Write(bs.buf.Bytes())
+	return err
+}
+
+// Bytes returns the output written so far.
+func (bs *BufferSink) Bytes() []byte {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return append([]byte(nil), bs.buf.Bytes()...)
+}
+
+// ErrOutputLimit is returned by a LimitSink once more than Limit bytes are written to it.
+type ErrOutputLimit struct {
+	Limit int64
+}
+
+func (err ErrOutputLimit) Error() string {
+	return fmt.Sprintf("output limit of %d bytes exceeded", err.Limit)
+}
+
+// LimitSink passes at most Limit bytes of the output on to Sink. The write that exceeds
+// the limit passes on the part of the output that fits and fails with ErrOutputLimit.
+type LimitSink struct {
+	Sink  OutputSink
+	Limit int64
+
+	mu      sync.Mutex
+	written int64
+}
+
+func (ls *LimitSink) Write(buf []byte) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if remaining := ls.Limit - ls.written; int64(len(buf)) > remaining {
+		n, err := ls.Sink.Write(buf[:remaining])
+		ls.written += int64(n)
+		if err == nil {
+			err = ErrOutputLimit{Limit: ls.Limit}
+		}
+		return n, err
+	}
+	n, err := ls.Sink.Write(buf)
+	ls.written += int64(n)
+	return n, err
+}
+
+func (ls *LimitSink) SetClock(clock func() time.Duration) {
+	if cs, ok := ls.Sink.(ClockedSink); ok {
+		cs.SetClock(clock)
+	}
+}
+
+func (ls *LimitSink) Close() error {
+	return ls.Sink.Close()
+}
+
+type teeSink []OutputSink
+
+// NewTeeSink creates a sink that passes the output on to all of sinks.
+func NewTeeSink(sinks ...OutputSink) OutputSink {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return teeSink(sinks)
+}
+
+func (ts teeSink) Write(buf []byte) (int, error) {
+	for _, s := range ts {
+		if n, err := s.Write(buf); err != nil {
+			return n, err
+		}
+	}
+	return len(buf), nil
+}
+
+func (ts teeSink) SetClock(clock func() time.Duration) {
+	for _, s := range ts {
+		if cs, ok := s.(ClockedSink); ok {
+			cs.SetClock(clock)
+		}
+	}
+}
+
+func (ts teeSink) Close() error {
+	var err error
+	for _, s := range ts {
+		if err1 := s.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// A Timeline collects the lines of output of all the instances, stamped with the simulated
+// times at which they were written, and writes them to Output ordered by these times.
+type Timeline struct {
+	Output io.Writer
+
+	mu    sync.Mutex
+	lines []timelineLine
+}
+
+type timelineLine struct {
+	time time.Duration
+	id   int
+	text string
+}
+
+// NewSink creates a sink for the output of the instance with the given ID. Each of its
+// lines is prefixed with tag.
+func (tl *Timeline) NewSink(id int, tag string) OutputSink {
+	return &timelineSink{tl: tl, id: id, tag: tag}
+}
+
+// Flush writes the collected lines to Output, ordered by their times, and forgets them.
+// Lines with equal times are ordered by instance IDs.
+func (tl *Timeline) Flush() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	// The sort is stable, so the lines of a single instance remain in order.
+	sort.SliceStable(tl.lines, func(i, j int) bool {
+		if tl.lines[i].time != tl.lines[j].time {
+			return tl.lines[i].time < tl.lines[j].time
+		}
+		return tl.lines[i].id < tl.lines[j].id
+	})
+	var err error
+	for _, line := range tl.lines {
+		if _, err = io.WriteString(tl.Output, line.text); err != nil {
+			break
+		}
+	}
+	tl.lines = nil
+	return err
+}
+
+type timelineSink struct {
+	tl  *Timeline
+	id  int
+	tag string
+
+	mu    sync.Mutex
+	clock func() time.Duration
+	line  []byte
+}
+
+func (ts *timelineSink) SetClock(clock func() time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.clock = clock
+}
+
+func (ts *timelineSink) Write(buf []byte) (int, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n := len(buf)
+	for len(buf) > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i == -1 {
+			ts.line = append(ts.line, buf...)
+			break
+		}
+		ts.line = append(ts.line, buf[:i]...)
+		ts.endLine()
+		buf = buf[i+1:]
+	}
+	return n, nil
+}
+
+func (ts *timelineSink) endLine() {
+	var t time.Duration
+	if ts.clock != nil {
+		t = ts.clock()
+	}
+	text := fmt.Sprintf("[%v] %s%s\n", t, ts.tag, ts.line)
+	ts.tl.mu.Lock()
+	ts.tl.lines = append(ts.tl.lines, timelineLine{time: t, id: ts.id, text: text})
+	ts.tl.mu.Unlock()
+	ts.line = ts.line[:0]
+}
+
+func (ts *timelineSink) Close() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if len(ts.line) > 0 {
+		ts.endLine()
+	}
+	return nil
+}
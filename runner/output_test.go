@@ -0,0 +1,238 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAll writes each of writes to sink and closes it.
+func writeAll(t *testing.T, sink OutputSink, writes ...string) {
+	for _, w := range writes {
+		if _, err := sink.Write([]byte(w)); err != nil {
+			t.Errorf("unexpected error when writing %q: %v", w, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("unexpected error when closing the sink: %v", err)
+	}
+}
+
+func TestPassthroughSink(t *testing.T) {
+	var buf bytes.Buffer
+	writeAll(t, NewPassthroughSink(&buf), "foo\nba", "r")
+	if got, want := buf.String(), "foo\nbar"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+}
+
+func TestTagSink(t *testing.T) {
+	var buf bytes.Buffer
+	writeAll(t, NewTagSink("TAG: ", &buf), "foo\nba", "r\n\nbaz")
+	if got, want := buf.String(), "TAG: foo\nTAG: bar\nTAG: \nTAG: baz\n"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(buf []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestTagSinkError(t *testing.T) {
+	sink := NewTagSink("TAG: ", failingWriter{})
+	// The error may only be noticed by a later write, or by Close.
+	sink.Write([]byte("foo\n"))
+	sink.Write([]byte("bar\n"))
+	if err := sink.Close(); err == nil {
+		t.Errorf("expected an error from a sink whose output fails")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parunner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "out")
+	sink, err := NewFileSink(filename)
+	if err != nil {
+		t.Fatalf("cannot create a file sink: %v", err)
+	}
+	writeAll(t, sink, "foo\n", "bar")
+	got, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "foo\nbar"; string(got) != want {
+		t.Errorf("wrong file contents: got=%q, want=%q", got, want)
+	}
+	if _, err := NewFileSink(filepath.Join(dir, "nonexistent", "out")); err == nil {
+		t.Errorf("expected an error when creating a file in a nonexistent directory")
+	}
+}
+
+func TestBufferSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &BufferSink{Output: &buf}
+	sink.Write([]byte("foo\n"))
+	sink.Write([]byte("bar"))
+	if buf.Len() != 0 {
+		t.Errorf("output written before the sink is closed: %q", buf.String())
+	}
+	if got, want := string(sink.Bytes()), "foo\nbar"; got != want {
+		t.Errorf("wrong buffered output: got=%q, want=%q", got, want)
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("unexpected error when closing the sink: %v", err)
+	}
+	if got, want := buf.String(), "foo\nbar"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+}
+
+func TestLimitSink(t *testing.T) {
+	buf := &BufferSink{}
+	sink := &LimitSink{Sink: buf, Limit: 5}
+	if n, err := sink.Write([]byte("foo")); n != 3 || err != nil {
+		t.Errorf("Write within the limit = %d, %v", n, err)
+	}
+	if n, err := sink.Write([]byte("barbaz")); n != 2 || err != (ErrOutputLimit{Limit: 5}) {
+		t.Errorf("Write over the limit = %d, %v", n, err)
+	}
+	if n, err := sink.Write([]byte("x")); n != 0 || err != (ErrOutputLimit{Limit: 5}) {
+		t.Errorf("Write after the limit = %d, %v", n, err)
+	}
+	if got, want := string(buf.Bytes()), "fooba"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+}
+
+func TestTeeSink(t *testing.T) {
+	var buf1, buf2 BufferSink
+	writeAll(t, NewTeeSink(&buf1, NewTagSink("TAG: ", &buf2)), "foo\n", "bar\n")
+	if got, want := string(buf1.Bytes()), "foo\nbar\n"; got != want {
+		t.Errorf("wrong output of the first sink: got=%q, want=%q", got, want)
+	}
+	if got, want := string(buf2.Bytes()), "TAG: foo\nTAG: bar\n"; got != want {
+		t.Errorf("wrong output of the second sink: got=%q, want=%q", got, want)
+	}
+
+	sink := NewTeeSink(NewPassthroughSink(failingWriter{}), &buf1)
+	if _, err := sink.Write([]byte("foo")); err == nil {
+		t.Errorf("expected an error from a tee with a failing sink")
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	var buf bytes.Buffer
+	tl := &Timeline{Output: &buf}
+	var times [2]time.Duration
+	sinks := make([]OutputSink, 2)
+	for i := range sinks {
+		i := i
+		sinks[i] = tl.NewSink(i, fmt.Sprintf("%d: ", i))
+		sinks[i].(ClockedSink).SetClock(func() time.Duration { return times[i] })
+	}
+	sinks[1].Write([]byte("b\nc"))
+	times[0] = 2
+	sinks[0].Write([]byte("d\n"))
+	times[1] = 1
+	sinks[1].Write([]byte("\n"))
+	sinks[0].Write([]byte("e"))
+	times[0] = 3
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			t.Errorf("unexpected error when closing a sink: %v", err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("output written before the timeline is flushed: %q", buf.String())
+	}
+	if err := tl.Flush(); err != nil {
+		t.Errorf("unexpected error from Flush: %v", err)
+	}
+	want := "[0s] 1: b\n[1ns] 1: c\n[2ns] 0: d\n[3ns] 0: e\n"
+	if got := buf.String(); got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+}
+
+func TestInstancesTimeline(t *testing.T) {
+	var buf bytes.Buffer
+	tl := &Timeline{Output: &buf}
+	// Instance 1 prints the message after it has waited for instance 0's computation.
+	inputs := []string{"C\nSbfoo\n", "Ra\n"}
+	cmds := make([]*exec.Cmd, len(inputs))
+	sinks := make([]OutputSink, len(inputs))
+	for i, input := range inputs {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+		sinks[i] = tl.NewSink(i, "")
+		cmds[i].Stdout = sinks[i]
+	}
+	if _, err := RunInstances(context.Background(), Options{Commands: cmds}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	for _, sink := range sinks {
+		sink.Close()
+	}
+	tl.Flush()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[2], "] 0 3 foo") || strings.HasPrefix(lines[2], "[0s]") {
+		t.Errorf("wrong timeline: got=%q, want the last line to be the received message with a nonzero time", lines)
+	}
+}
+
+func TestInstancesOutputLimit(t *testing.T) {
+	// Instance 1 would wait forever for a message from instance 0 if it wasn't killed after
+	// exceeding the limit with its first line of output.
+	cmds := make([]*exec.Cmd, 2)
+	for i, input := range []string{"H\n", "Ra\n"} {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+	}
+	cmds[1].Stdout = &LimitSink{Sink: &BufferSink{}, Limit: 2}
+	_, err := RunInstances(context.Background(), Options{Commands: cmds})
+	ie, ok := err.(InstanceError)
+	if !ok {
+		t.Fatalf("expected an InstanceError, got %v", err)
+	}
+	if _, ok := ie.Err.(ErrOutputLimit); !ok || ie.ID != 1 {
+		t.Errorf("expected ErrOutputLimit of instance 1, got %v", ie)
+	}
+	if got, want := ie.Termination.Verdict(), "output limit exceeded"; got != want {
+		t.Errorf("wrong verdict: got=%q, want=%q", got, want)
+	}
+}
+
+func TestInstancesContestOutput(t *testing.T) {
+	cs := &ContestStdout{Output: ioutil.Discard, SingleNode: true, Node: 0}
+	cmds := make([]*exec.Cmd, 2)
+	for i := range cmds {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader("H\n")
+		cmds[i].Stdout = cs.NewWriter(i)
+	}
+	_, err := RunInstances(context.Background(), Options{Commands: cmds})
+	ie, ok := err.(InstanceError)
+	if !ok {
+		t.Fatalf("expected an InstanceError, got %v", err)
+	}
+	if ie.ID != 1 {
+		t.Errorf("wrong instance has failed: got=%d, want=1", ie.ID)
+	}
+	if got, want := ie.Termination.Verdict(), "unexpected output"; got != want {
+		t.Errorf("wrong verdict: got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// A process is a program run by an Instance. The program talks to parunner using the
+// zeus protocol over a pair of streams.
+type process interface {
+	// Start starts the program. The instance's requests can then be read from requests
+	// and the responses should be written to responses. The caller closes both streams
+	// once it is done communicating.
+	Start() (requests io.ReadCloser, responses io.WriteCloser, err error)
+	// Wait waits for the program to finish and returns the CPU time it has used.
+	// The streams returned by Start remain usable until Close is called.
+	Wait() (time.Duration, error)
+	// Close is called after Wait returns. It makes the streams returned by Start report
+	// the end of communication.
+	Close()
+	// Kill forcibly terminates the program.
+	Kill() error
+}
+
+// localProcess runs a command as a child process, connected through a pair of pipes.
+type localProcess struct {
+	cmd *exec.Cmd
+	// shm is passed to the child if non-nil.
+	shm *os.File
+	// stopAtStart makes the child stop before it executes the command's binary.
+	stopAtStart bool
+	// sandbox makes the child run in a sandbox.
+	sandbox bool
+	// workdir is the directory that the sandbox's working directory is mounted on.
+	workdir string
+	// cpus, if non-nil, are the CPUs that the child is pinned to.
+	cpus []int
+	// memoryLimit, if positive, limits the memory of the child, in bytes. memory is the
+	// cgroup that enforces it.
+	memoryLimit int64
+	memory      *memoryCgroup
+
+	// The child's ends of the pipes.
+	respr, cmdw *os.File
+
+	mu sync.Mutex
+	// exited is set once the child's process group may no longer exist, so that its ID
+	// could be reused.
+	exited bool
+}
+
+func (p *localProcess) Start() (io.ReadCloser, io.WriteCloser, error) {
+	cmdr, cmdw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	respr, respw, err := os.Pipe()
+	if err != nil {
+		cmdr.Close()
+		cmdw.Close()
+		return nil, nil, err
+	}
+	fail := func(err error) (io.ReadCloser, io.WriteCloser, error) {
+		for _, f := range []*os.File{cmdr, cmdw, respr, respw} {
+			f.Close()
+		}
+		p.removeWorkdir()
+		p.removeCgroup()
+		return nil, nil, err
+	}
+	if p.stopAtStart {
+		// The sandbox's system call filter and its PID namespace wouldn't let the helper
+		// stop itself.
+		if p.sandbox {
+			return fail(errors.New("a sandboxed instance can't be stopped at start"))
+		}
+		if err := stopCommand(p.cmd); err != nil {
+			return fail(err)
+		}
+	}
+	if p.sandbox {
+		if p.workdir, err = sandboxCommand(p.cmd); err != nil {
+			return fail(err)
+		}
+	}
+	if p.memoryLimit > 0 {
+		if p.memory, err = newMemoryCgroup(p.memoryLimit); err != nil {
+			return fail(err)
+		}
+		p.memory.prepare(p.cmd)
+	}
+	start := func() error {
+		return startInstance(p.cmd, respr, cmdw, p.shm)
+	}
+	if p.cpus != nil {
+		err = startPinned(p.cpus, start)
+	} else {
+		err = start()
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if p.memory != nil {
+		if err := p.memory.add(p.cmd.Process.Pid); err != nil {
+			p.Kill()
+			p.cmd.Wait()
+			return fail(err)
+		}
+	}
+	p.respr, p.cmdw = respr, cmdw
+	return cmdr, respw, nil
+}
+
+func (p *localProcess) Wait() (time.Duration, error) {
+	// Processes left behind by the child could keep its output streams open and prevent
+	// cmd.Wait from returning, so we kill them as soon as the child exits.
+	if waitExited(p.cmd.Process) == nil {
+		p.Kill()
+		p.setExited()
+	}
+	err := p.cmd.Wait()
+	p.setExited()
+	if p.sandbox {
+		err = sandboxError(err)
+	}
+	if err != nil && p.memory != nil && p.memory.oomKilled() {
+		err = ErrMemoryLimit{Limit: p.memoryLimit, Err: err}
+	}
+	return p.cmd.ProcessState.SystemTime() + p.cmd.ProcessState.UserTime(), err
+}
+
+func (p *localProcess) Close() {
+	p.respr.Close()
+	p.cmdw.Close()
+	p.removeWorkdir()
+	p.removeCgroup()
+}
+
+func (p *localProcess) removeWorkdir() {
+	if p.workdir != "" {
+		os.Remove(p.workdir)
+	}
+}
+
+func (p *localProcess) removeCgroup() {
+	if p.memory != nil {
+		p.memory.remove()
+	}
+}
+
+// Pause stops the child and the processes it has started.
+func (p *localProcess) Pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited {
+		return errors.New("the process has already exited")
+	}
+	return pauseInstance(p.cmd)
+}
+
+// Resume continues the processes stopped by Pause.
+func (p *localProcess) Resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited {
+		return errors.New("the process has already exited")
+	}
+	return resumeInstance(p.cmd)
+}
+
+func (p *localProcess) setExited() {
+	p.mu.Lock()
+	p.exited = true
+	p.mu.Unlock()
+}
+
+// Kill kills the child together with any processes it has started.
+func (p *localProcess) Kill() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited {
+		return p.cmd.Process.Kill()
+	}
+	return killInstance(p.cmd)
+}
@@ -0,0 +1,27 @@
+package runner
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const pPid = 1
+
+// waitExited waits for p to exit without reaping it, so that its process ID (and thus its
+// process group ID) can't be reused until it is waited for.
+func waitExited(p *os.Process) error {
+	// Large enough for a siginfo_t on all architectures.
+	var info [128]byte
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(p.Pid), uintptr(unsafe.Pointer(&info[0])), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		default:
+			return os.NewSyscallError("waitid", errno)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// +build !linux
+
+package runner
+
+import (
+	"errors"
+	"os"
+)
+
+func waitExited(p *os.Process) error {
+	return errors.New("waiting without reaping is not supported on this platform")
+}
@@ -0,0 +1,240 @@
+package runner
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// A job describes a command that a worker should run.
+type job struct {
+	Path string
+	Args []string
+	Dir  string
+	// Token is the secret that the worker requires to run the command.
+	Token string
+}
+
+// An exitStatus describes how a command run by a worker has finished.
+type exitStatus struct {
+	// Err is the error returned when waiting for the command, or empty if there was none.
+	Err     string
+	CPUTime time.Duration
+	// Termination describes how the command has terminated, if it has been run.
+	Termination *Termination
+}
+
+// RemoteError is an error that has occurred on a worker.
+type RemoteError struct {
+	Worker string
+	Msg    string
+	// Termination describes how the command run by the worker has terminated, if the error
+	// was caused by its termination.
+	Termination *Termination
+}
+
+func (e RemoteError) Error() string {
+	return fmt.Sprintf("%s (on worker %s)", e.Msg, e.Worker)
+}
+
+// remoteProcess runs a command on a parunner worker. The command's standard streams are
+// forwarded to and from the streams set in cmd.
+type remoteProcess struct {
+	addr  string
+	token string
+	cmd   *exec.Cmd
+
+	m      *mux
+	copies sync.WaitGroup
+	closed chan bool
+}
+
+func (p *remoteProcess) Start() (io.ReadCloser, io.WriteCloser, error) {
+	conn, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.m = newMux(conn)
+	p.closed = make(chan bool)
+	buf, err := json.Marshal(&job{Path: p.cmd.Path, Args: p.cmd.Args, Dir: p.cmd.Dir, Token: p.token})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := p.m.writeFrame(chanControl, buf); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	stdin := p.m.Writer(chanStdio)
+	go func() {
+		if p.cmd.Stdin != nil {
+			// As with local processes, we don't care if the instance doesn't read its whole input.
+			io.Copy(stdin, p.cmd.Stdin)
+		}
+		stdin.Close()
+	}()
+	forward := func(w io.Writer, r io.ReadCloser) {
+		if w == nil {
+			w = ioutil.Discard
+		}
+		p.copies.Add(1)
+		go func() {
+			io.Copy(w, r)
+			r.Close()
+			p.copies.Done()
+		}()
+	}
+	forward(p.cmd.Stdout, p.m.Reader(chanStdio))
+	forward(p.cmd.Stderr, p.m.Reader(chanStderr))
+	return &remoteRequests{p.m.Reader(chanComm), p.closed}, p.m.Writer(chanComm), nil
+}
+
+// remoteRequests delays the end of the request stream until the process is closed, just
+// like the pipe of a local process is only closed after the process is waited for.
+type remoteRequests struct {
+	io.ReadCloser
+	closed <-chan bool
+}
+
+func (rr *remoteRequests) Read(buf []byte) (int, error) {
+	n, err := rr.ReadCloser.Read(buf)
+	if err != nil {
+		<-rr.closed
+	}
+	return n, err
+}
+
+func (p *remoteProcess) Wait() (time.Duration, error) {
+	var status exitStatus
+	err := json.NewDecoder(p.m.Reader(chanControl)).Decode(&status)
+	p.copies.Wait()
+	if err != nil {
+		return 0, RemoteError{Worker: p.addr, Msg: fmt.Sprintf("lost connection: %v", err)}
+	}
+	if status.Err != "" {
+		return status.CPUTime, RemoteError{Worker: p.addr, Msg: status.Err, Termination: status.Termination}
+	}
+	return status.CPUTime, nil
+}
+
+func (p *remoteProcess) Close() {
+	close(p.closed)
+	p.m.Close()
+}
+
+func (p *remoteProcess) Kill() error {
+	return p.m.Writer(chanKill).Close()
+}
+
+// ServeWorker accepts connections on l and runs the job sent over each of them. A job is only
+// run if it comes with the given token, unless the token is empty, in which case anyone who
+// can connect to l can run any command as this process. The token is sent in plain text, so
+// it only protects the worker on a network that can't be eavesdropped on. If sandbox is set,
+// the commands are run in a sandbox, as with Instance.Sandbox.
+func ServeWorker(l net.Listener, token string, sandbox bool) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := runJob(conn, token, sandbox); err != nil {
+				log.Printf("job from %v failed: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// errWrongToken is the error of a job that didn't come with the worker's token.
+var errWrongToken = errors.New("the job's token doesn't match the worker's")
+
+func runJob(conn net.Conn, token string, sandbox bool) error {
+	m := newMux(conn)
+	defer m.Close()
+	var j job
+	if err := json.NewDecoder(m.Reader(chanControl)).Decode(&j); err != nil {
+		return err
+	}
+	stdout, stderr := m.Writer(chanStdio), m.Writer(chanStderr)
+	var status exitStatus
+	var jobErr error
+	if token != "" && subtle.ConstantTimeCompare([]byte(j.Token), []byte(token)) != 1 {
+		jobErr = errWrongToken
+		status.Err = jobErr.Error()
+		// Nothing reads the job's input, which mustn't hold up the rest of the connection.
+		m.Reader(chanStdio).Close()
+		m.Reader(chanComm).Close()
+	} else {
+		if len(j.Args) == 0 {
+			j.Args = []string{j.Path}
+		}
+		cmd := exec.Command(j.Path, j.Args[1:]...)
+		cmd.Dir = j.Dir
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		err := runJobProcess(m, cmd, sandbox, &status)
+		if err != nil {
+			status.Err = err.Error()
+		}
+		t := processTermination(err)
+		status.Termination = &t
+	}
+	stdout.Close()
+	stderr.Close()
+	buf, err := json.Marshal(&status)
+	if err != nil {
+		return err
+	}
+	if err := m.writeFrame(chanControl, buf); err != nil {
+		return err
+	}
+	// Wait for the coordinator to hang up, so that it receives everything we've sent.
+	io.Copy(ioutil.Discard, m.Reader(chanKill))
+	return jobErr
+}
+
+// runJobProcess runs cmd, connecting its streams to m's channels.
+func runJobProcess(m *mux, cmd *exec.Cmd, sandbox bool, status *exitStatus) error {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	p := &localProcess{cmd: cmd, sandbox: sandbox}
+	requests, responses, err := p.Start()
+	if err != nil {
+		return err
+	}
+	// Readers of the mux's channels are closed as soon as we stop reading them, so that
+	// the rest of the connection doesn't get stuck.
+	copyAndClose := func(w io.WriteCloser, r io.ReadCloser) {
+		io.Copy(w, r)
+		w.Close()
+		r.Close()
+	}
+	go copyAndClose(stdin, m.Reader(chanStdio))
+	go copyAndClose(responses, m.Reader(chanComm))
+	commDone := make(chan bool)
+	go func() {
+		copyAndClose(m.Writer(chanComm), requests)
+		close(commDone)
+	}()
+	go func() {
+		// The kill channel ends either when the coordinator asks us to kill the instance
+		// or when the connection is lost. In both cases we don't want the instance to run anymore.
+		io.Copy(ioutil.Discard, m.Reader(chanKill))
+		p.Kill()
+	}()
+	status.CPUTime, err = p.Wait()
+	// Let the instance's requests drain before we report the exit status.
+	p.Close()
+	<-commDone
+	return err
+}
@@ -0,0 +1,120 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	a, b := net.Pipe()
+	ma, mb := newMux(a), newMux(b)
+	defer ma.Close()
+	defer mb.Close()
+	w := ma.Writer(chanStdio)
+	big := bytes.Repeat([]byte("x"), maxFrameSize+10)
+	go func() {
+		w.Write([]byte("foo"))
+		w.Write(big)
+		w.Close()
+		ma.Writer(chanStderr).Close()
+	}()
+	// Nobody reads chanStderr, but this must not block chanStdio once the reader is closed.
+	mb.Reader(chanStderr).Close()
+	got, err := ioutil.ReadAll(mb.Reader(chanStdio))
+	if err != nil {
+		t.Fatalf("error reading from a mux channel: %v", err)
+	}
+	if want := append([]byte("foo"), big...); !bytes.Equal(got, want) {
+		t.Errorf("wrong data read from a mux channel: got %d bytes, want %d bytes", len(got), len(want))
+	}
+	if _, err := w.Write([]byte("bar")); err != io.ErrClosedPipe {
+		t.Errorf("writing to a closed mux channel: got error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+// startWorkers starts n workers with a given token listening on the loopback interface, that
+// are stopped when the test finishes.
+func startWorkers(t *testing.T, n int, token string) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go ServeWorker(l, token, false)
+		addrs[i] = l.Addr().String()
+	}
+	return addrs
+}
+
+func TestInstancesRemote(t *testing.T) {
+	workers := startWorkers(t, 2, "")
+	var outputs [3]bytes.Buffer
+	cmds := make([]*exec.Cmd, 3)
+	for i, input := range []string{"Rb\nRc\n", "Safoo\n", "C\nSabarbaz\n"} {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+		cmds[i].Stdout = &outputs[i]
+	}
+	instances, err := RunInstances(context.Background(), Options{Commands: cmds, Workers: workers})
+	if err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	for i, want := range []string{"0 3\n1 3 foo\n2 6 barbaz\n", "1 3\n", "2 3\n"} {
+		if got := strings.Replace(outputs[i].String(), "\r\n", "\n", -1); got != want {
+			t.Errorf("wrong output from instance %d: got=%q, want=%q", i, got, want)
+		}
+	}
+	if instances[2].TimeRunning == 0 {
+		t.Errorf("remote instance that burns CPU reported no CPU time")
+	}
+	if instances[0].Worker == instances[1].Worker {
+		t.Errorf("instances 0 and 1 were run on the same worker %s", instances[0].Worker)
+	}
+
+	cmds = []*exec.Cmd{exec.Command(testerPath), exec.Command(testerPath)}
+	cmds[1].Stdin = strings.NewReader("Q 1\n")
+	if _, err := RunInstances(context.Background(), Options{Commands: cmds, Workers: workers}); err == nil {
+		t.Errorf("no error from a failing remote instance")
+	} else if ie, ok := err.(InstanceError); !ok || ie.ID != 1 {
+		t.Errorf("unexpected error from a failing remote instance: %v", err)
+	}
+}
+
+func TestInstancesRemoteToken(t *testing.T) {
+	workers := startWorkers(t, 1, "secret")
+	for _, tc := range []struct {
+		token string
+		ok    bool
+	}{
+		{"secret", true},
+		{"", false},
+		{"wrong", false},
+	} {
+		var output bytes.Buffer
+		cmd := exec.Command(testerPath)
+		cmd.Stdin = strings.NewReader("C\n")
+		cmd.Stdout = &output
+		_, err := RunInstances(context.Background(), Options{Commands: []*exec.Cmd{cmd}, Workers: workers, WorkerToken: tc.token})
+		if tc.ok {
+			if err != nil {
+				t.Errorf("unexpected error from an instance with the worker's token: %v", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), errWrongToken.Error()) {
+			t.Errorf("wrong error from an instance with token %q: %v", tc.token, err)
+		}
+		// The tester prints its ID as soon as it starts.
+		if output.Len() != 0 {
+			t.Errorf("an instance with token %q was run: output %q", tc.token, output.String())
+		}
+	}
+}
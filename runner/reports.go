@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Patterns of the lines that tools like valgrind and the sanitizers print when they find
+// an error in the program they check. The first submatch names the tool, if it varies.
+var toolReportPatterns = []struct {
+	tool string
+	re   *regexp.Regexp
+}{
+	{"", regexp.MustCompile(`^==\d+==\s*ERROR: (\w+Sanitizer)`)},
+	{"", regexp.MustCompile(`^WARNING: (ThreadSanitizer):`)},
+	{"UndefinedBehaviorSanitizer", regexp.MustCompile(`^\S+:\d+:\d+: runtime error: `)},
+	{"valgrind", regexp.MustCompile(`^==\d+== ERROR SUMMARY: [1-9]\d* errors`)},
+}
+
+// ErrToolReport is returned when an instance's stderr contains an error report of a tool
+// like valgrind or AddressSanitizer, even if the instance has otherwise finished successfully.
+// It is usually encapsulated in an InstanceError that specifies the instance ID.
+type ErrToolReport struct {
+	Tool string
+	Line string
+}
+
+func (err ErrToolReport) Error() string {
+	return fmt.Sprintf("%s has reported an error: %s", err.Tool, err.Line)
+}
+
+// reportDetector is a writer that looks for the first error report of a tool in the lines
+// written to it.
+type reportDetector struct {
+	mu     sync.Mutex
+	line   []byte
+	report *ErrToolReport
+}
+
+func (rd *reportDetector) Write(buf []byte) (int, error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	for _, b := range buf {
+		if rd.report != nil {
+			break
+		}
+		if b == '\n' {
+			rd.checkLine()
+			rd.line = rd.line[:0]
+			continue
+		}
+		// The reports we look for are at the beginnings of the lines.
+		if len(rd.line) < maxTailLineLength {
+			rd.line = append(rd.line, b)
+		}
+	}
+	return len(buf), nil
+}
+
+func (rd *reportDetector) checkLine() {
+	for _, p := range toolReportPatterns {
+		m := p.re.FindSubmatch(rd.line)
+		if m == nil {
+			continue
+		}
+		tool := p.tool
+		if tool == "" {
+			tool = string(m[1])
+		}
+		rd.report = &ErrToolReport{Tool: tool, Line: string(rd.line)}
+		return
+	}
+}
+
+// Report returns the first report found, or nil if there was none.
+func (rd *reportDetector) Report() *ErrToolReport {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.report == nil && len(rd.line) > 0 {
+		rd.checkLine()
+	}
+	return rd.report
+}
@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReportDetector(t *testing.T) {
+	for _, tc := range []struct {
+		output string
+		tool   string
+	}{
+		{"", ""},
+		{"foo\nbar\n", ""},
+		{"==123== ERROR SUMMARY: 0 errors from 0 contexts (suppressed: 0 from 0)\n", ""},
+		{"==123== Invalid read of size 4\n==123== ERROR SUMMARY: 1 errors from 1 contexts (suppressed: 0 from 0)\n", "valgrind"},
+		{"=================================================================\n==4567==ERROR: AddressSanitizer: heap-use-after-free on address 0x602000000010\n", "AddressSanitizer"},
+		{"==4567==ERROR: LeakSanitizer: detected memory leaks", "LeakSanitizer"},
+		{"a.cc:3:5: runtime error: signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'\n", "UndefinedBehaviorSanitizer"},
+		{"WARNING: ThreadSanitizer: data race (pid=1)\n", "ThreadSanitizer"},
+		{"panic: runtime error: index out of range [3] with length 3\n", ""},
+		{"the answer is: runtime error: none\n", ""},
+	} {
+		rd := &reportDetector{}
+		// Split the output into small writes, to check that lines are reassembled.
+		for s := tc.output; s != ""; {
+			n := 7
+			if n > len(s) {
+				n = len(s)
+			}
+			rd.Write([]byte(s[:n]))
+			s = s[n:]
+		}
+		report := rd.Report()
+		switch {
+		case tc.tool == "" && report != nil:
+			t.Errorf("unexpected report in %q: %v", tc.output, report)
+		case tc.tool != "" && report == nil:
+			t.Errorf("no report found in %q", tc.output)
+		case tc.tool != "" && (report.Tool != tc.tool || !strings.Contains(tc.output, report.Line)):
+			t.Errorf("wrong report found in %q: %+v, want tool %s", tc.output, report, tc.tool)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package runner
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"time"
+)
+
+// An ErrDeadlock represents a situation in which all of the instances have either
+// finished or are waiting for a message.
+type ErrDeadlock struct {
+	// WaitingInstances lists the instances that are still alive and trying to receive a message.
+	WaitingInstances []int
+	// RemainingMessages lists the pairs of instances that have unreceived messages between them.
+	RemainingMessages []struct{ From, To int }
+}
+
+func (e ErrDeadlock) Error() string {
+	return "all instances have either terminated or are deadlocked"
+}
+
+// An ErrRemainingMessages represents a situation when some messages were left
+// in the queues (ie. weren't received) when all the instances have finished.
+// This situation should not be considered an error, but we should warn about it.
+type ErrRemainingMessages struct {
+	// RemainingMessages lists the pairs of instances that have unreceived messages between them.
+	RemainingMessages []struct{ From, To int }
+}
+
+func (e ErrRemainingMessages) Error() string {
+	return "some messages were left unreceived after all instances have terminated"
+}
+
+// requestAndID represents a request r made by instance id
+type requestAndID struct {
+	id int
+	r  *request
+}
+
+// requestHeap is a min-heap of pending requests, ordered by timestamp and then by the
+// instance ID, so that the order of simultaneous requests is deterministic.
+type requestHeap []*requestAndID
+
+func (h requestHeap) Len() int { return len(h) }
+func (h requestHeap) Less(i, j int) bool {
+	if h[i].r.time != h[j].r.time {
+		return h[i].r.time < h[j].r.time
+	}
+	return h[i].id < h[j].id
+}
+func (h requestHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *requestHeap) Push(x interface{}) { *h = append(*h, x.(*requestAndID)) }
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// merge reads requests from a slice of input channels and calls fn for every request in
+// timestamp order. When fn return a pair (i, b) we assume that from this point on input channel
+// i is blocked iff b is true. We assume that:
+//   * every input channel produces requests in ascending timestamp order,
+//   * when a channel is blocked it will not produce any requests,
+//   * an unblocked channel will only produce requests with timestamps later than that of
+//     the request that unblocked it most recently,
+//   * an unblocked channel will eventually produce a request or close.
+// merge returns when all input channels are closed or blocked. merge returns the indexes of
+// the channels that are blocked.
+//
+// If done is closed before that, merge stops calling fn, reads and discards the requests
+// until all input channels are closed, and returns with interrupted set.
+//
+// If delays is non-nil, its events are handled in timestamp order together with the requests,
+// before the requests with the same timestamp. merge only returns once there are none left.
+//
+// The earliest request can only be chosen once every unblocked channel has a pending request
+// (or is closed). We keep the pending requests in a heap, so each request costs O(log N).
+func merge(done <-chan struct{}, inputs []<-chan *request, delays delayQueue, fn func(*requestAndID) (int, bool)) (deadlocked []int, interrupted bool) {
+	blocked := make([]bool, len(inputs))
+	// pending[i] is set if there is a request from channel i in the heap.
+	pending := make([]bool, len(inputs))
+	closed := make([]bool, len(inputs))
+	var h requestHeap
+	// fill reads the next request from channel i if it may produce one.
+	fill := func(i int) {
+		if blocked[i] || pending[i] || closed[i] || interrupted {
+			return
+		}
+		var r *request
+		var ok bool
+		select {
+		case r, ok = <-inputs[i]:
+		case <-done:
+			interrupted = true
+			return
+		}
+		if !ok {
+			closed[i] = true
+			return
+		}
+		pending[i] = true
+		heap.Push(&h, &requestAndID{id: i, r: r})
+	}
+	for i := range inputs {
+		fill(i)
+	}
+	for !interrupted {
+		select {
+		case <-done:
+			interrupted = true
+			continue
+		default:
+		}
+		if delays != nil {
+			if t, ok := delays.next(); ok && (h.Len() == 0 || t <= h[0].r.time) {
+				i, block := delays.fire()
+				blocked[i] = block
+				fill(i)
+				continue
+			}
+		}
+		if h.Len() == 0 {
+			break
+		}
+		first := heap.Pop(&h).(*requestAndID)
+		pending[first.id] = false
+		i, block := fn(first)
+		blocked[i] = block
+		fill(first.id)
+		fill(i)
+	}
+	if interrupted {
+		// The instances that send the requests may only finish once we read them.
+		for i, input := range inputs {
+			if !closed[i] {
+				for range input {
+				}
+			}
+		}
+		return nil, true
+	}
+	// Either all the channels are closed or all the channels that aren't are in blocking requests.
+	// In the latter case a deadlock has occurred, because nothing can unblock them anymore.
+	var blockedInstances []int
+	for i, b := range blocked {
+		if b {
+			blockedInstances = append(blockedInstances, i)
+		}
+	}
+	return blockedInstances, false
+}
+
+// A delayQueue holds events that happen at given simulated times, independently of the requests.
+type delayQueue interface {
+	// next returns the time of the earliest event, or false if there are none.
+	next() (time.Duration, bool)
+	// fire handles the earliest event. Its results are like those of merge's fn.
+	fire() (int, bool)
+}
+
+// A queueSet contains the incoming message queues of one instance.
+type queueSet struct {
+	id        int
+	queues    map[int][]*Message
+	receiveFn func() (*response, bool)
+	// receiveTime is the time of the receive request that receiveFn handles.
+	receiveTime time.Duration
+	output      chan<- *response
+	observer    Observer
+	chooser     Chooser
+	// anyReceives is the number of requests to receive from any instance handled so far.
+	anyReceives int
+	// shown and taken count the messages from each instance that have become visible to
+	// requests to receive from any instance and that have been received. Without an
+	// adversary, the messages become visible as soon as they are sent.
+	shown, taken map[int]int
+	adversary    *adversaryQueue
+}
+
+func newQueueSet(id int, output chan<- *response, opts RouteOptions) *queueSet {
+	return &queueSet{
+		id:       id,
+		queues:   make(map[int][]*Message),
+		output:   output,
+		observer: opts.Observer,
+		chooser:  opts.Chooser,
+		shown:    make(map[int]int),
+		taken:    make(map[int]int),
+	}
+}
+
+// chooseSender returns the instance whose message is received by the index-th request
+// to receive from any instance, or false if there are no visible messages.
+func (qs *queueSet) chooseSender(index int) (int, bool) {
+	var senders []int
+	for i := range qs.queues {
+		if qs.shown[i] > qs.taken[i] {
+			if qs.chooser == nil {
+				return i, true
+			}
+			senders = append(senders, i)
+		}
+	}
+	switch len(senders) {
+	case 0:
+		return 0, false
+	case 1:
+		return senders[0], true
+	}
+	sort.Ints(senders)
+	return qs.chooser.Choose(qs.id, index, senders), true
+}
+
+func (qs *queueSet) dequeue(from int) *Message {
+	qs.taken[from]++
+	ms := qs.queues[from]
+	if len(ms) > 1 {
+		qs.queues[from] = ms[1:]
+	} else {
+		delete(qs.queues, from)
+	}
+	return ms[0]
+}
+
+// handleRequest handles a receive request from this instance or a send request
+// to this instance. handleRequest returns true iff the instance is now blocked
+// and won't emit any requests itself until unblocked by an incoming message.
+func (qs *queueSet) handleRequest(req *requestAndID) (blocked bool) {
+	switch req.r.requestType {
+	case requestSend:
+		observe(qs.observer, Event{Type: MessageSent, Instance: req.id, Peer: qs.id, Time: req.r.time, Size: req.r.messageLen()})
+		qs.queues[req.id] = append(qs.queues[req.id],
+			&Message{
+				Source:   req.id,
+				Target:   req.r.destination,
+				SendTime: req.r.time,
+				Message:  req.r.message,
+				Stored:   req.r.stored,
+			})
+		if qs.adversary != nil {
+			qs.adversary.delay(req.id, qs.id, req.r.time)
+		} else {
+			qs.shown[req.id]++
+		}
+	case requestRecv:
+		observe(qs.observer, Event{Type: ReceiveBlocked, Instance: qs.id, Peer: req.r.source, Time: req.r.time})
+		if qs.receiveFn != nil {
+			panic("two simultaneous receives")
+		}
+		qs.receiveTime = req.r.time
+		qs.receiveFn = func() (*response, bool) {
+			if _, ok := qs.queues[req.r.source]; ok {
+				return &response{message: qs.dequeue(req.r.source)}, true
+			}
+			return nil, false
+		}
+	case requestRecvAny:
+		observe(qs.observer, Event{Type: ReceiveBlocked, Instance: qs.id, Peer: -1, Time: req.r.time})
+		if qs.receiveFn != nil {
+			panic("two simultaneous receives")
+		}
+		qs.receiveTime = req.r.time
+		index := qs.anyReceives
+		qs.anyReceives++
+		qs.receiveFn = func() (*response, bool) {
+			sender, ok := qs.chooseSender(index)
+			if !ok {
+				return nil, false
+			}
+			return &response{message: qs.dequeue(sender)}, true
+		}
+	}
+	return qs.tryReceive()
+}
+
+// show makes the next message from the given instance visible to requests to receive from
+// any instance. It returns true iff the instance is still blocked.
+func (qs *queueSet) show(from int) (blocked bool) {
+	qs.shown[from]++
+	return qs.tryReceive()
+}
+
+// tryReceive responds to the receive request of the instance, if there is one and a message
+// for it is available. It returns true iff the instance is blocked.
+func (qs *queueSet) tryReceive() (blocked bool) {
+	if qs.receiveFn != nil {
+		if response, ok := qs.receiveFn(); ok {
+			t := qs.receiveTime
+			if response.message.SendTime > t {
+				t = response.message.SendTime
+			}
+			observe(qs.observer, Event{Type: MessageDelivered, Instance: qs.id, Peer: response.message.Source, Time: t, Size: response.message.Len()})
+			qs.output <- response
+			qs.receiveFn = nil
+		}
+	}
+	return qs.receiveFn != nil
+}
+
+// RouteOptions modify the routing of messages by RouteMessages.
+type RouteOptions struct {
+	// Observer, if non-nil, is notified of the events of the messages.
+	Observer Observer
+	// Chooser, if non-nil, decides which message is received when messages from several
+	// instances are waiting for a request to receive from any instance. Otherwise the
+	// choice is arbitrary.
+	Chooser Chooser
+	// Adversary, if non-nil, delays the messages before requests to receive from any
+	// instance can receive them.
+	Adversary *Adversary
+}
+
+// RouteMessages processes requests (send and receives) from a set of instances and sends back responses
+// to requests that require them. It should be given two slices of equal size: requestChans[i] should
+// be the channel that provides the requests from instance i and responses to that instance will be delivered
+// to responseChans[i]. The function will return once all requests are processed and all input channels are closed,
+// or once an error occurs. The function leaves output channels open. The routing can be modified
+// by opts.
+//
+// If ctx is done before the function returns, it stops routing messages, waits until all
+// input channels are closed (discarding the requests that arrive in the meantime) and
+// returns ctx.Err(). The caller should make the instances stop, e.g. by killing them.
+//
+// Prerequisites:
+// Each output channel must be buffered.
+// A request that requires a response must not be followed by another request until the response is read.
+func RouteMessages(ctx context.Context, requestChans []<-chan *request, responseChans []chan<- *response, opts RouteOptions) error {
+	queueSets := make([]*queueSet, len(requestChans))
+	for i, output := range responseChans {
+		queueSets[i] = newQueueSet(i, output, opts)
+	}
+	var delays delayQueue
+	if opts.Adversary != nil {
+		aq := newAdversaryQueue(opts.Adversary, queueSets)
+		for _, qs := range queueSets {
+			qs.adversary = aq
+		}
+		delays = aq
+	}
+	blocked, interrupted := merge(ctx.Done(), requestChans, delays, func(req *requestAndID) (int, bool) {
+		var target int
+		switch req.r.requestType {
+		case requestSend:
+			target = req.r.destination
+		default:
+			target = req.id
+		}
+		return target, queueSets[target].handleRequest(req)
+	})
+	if interrupted {
+		return ctx.Err()
+	}
+	var remaining []struct{ From, To int }
+	for i, qs := range queueSets {
+		for j := range qs.queues {
+			remaining = append(remaining, struct{ From, To int }{j, i})
+		}
+	}
+	if len(blocked) > 0 {
+		observe(opts.Observer, Event{Type: Deadlock, Instance: -1, Waiting: blocked})
+		return ErrDeadlock{WaitingInstances: blocked, RemainingMessages: remaining}
+	}
+	if len(remaining) > 0 {
+		return ErrRemainingMessages{RemainingMessages: remaining}
+	}
+	return nil
+}
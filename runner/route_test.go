@@ -0,0 +1,217 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeInstance struct {
+	requestChan  chan *request
+	responseChan chan *response
+	fakeTime     time.Duration
+}
+
+func newFakeInstance() *fakeInstance {
+	return &fakeInstance{
+		requestChan:  make(chan *request, 1),
+		responseChan: make(chan *response, 1),
+	}
+}
+
+func (fi *fakeInstance) Send(destination int, contents []byte) {
+	fi.fakeTime++
+	fi.requestChan <- &request{
+		requestType: requestSend,
+		time:        fi.fakeTime,
+		destination: destination,
+		message:     contents,
+	}
+}
+
+func (fi *fakeInstance) RecvFrom(source int) *Message {
+	fi.fakeTime++
+	fi.requestChan <- &request{
+		requestType: requestRecv,
+		time:        fi.fakeTime,
+		source:      source,
+	}
+	resp := <-fi.responseChan
+	if resp.message.SendTime > fi.fakeTime {
+		fi.fakeTime = resp.message.SendTime
+	}
+	resp.message.SendTime = 0
+	return resp.message
+}
+
+func (fi *fakeInstance) Recv() *Message {
+	fi.fakeTime++
+	fi.requestChan <- &request{
+		requestType: requestRecvAny,
+		time:        fi.fakeTime,
+	}
+	resp := <-fi.responseChan
+	if resp.message.SendTime > fi.fakeTime {
+		fi.fakeTime = resp.message.SendTime
+	}
+	resp.message.SendTime = 0
+	return resp.message
+}
+
+func (fi *fakeInstance) Close() {
+	close(fi.requestChan)
+}
+
+func setupFakes(n int) []*fakeInstance {
+	fis := make([]*fakeInstance, n)
+	for i := range fis {
+		fis[i] = newFakeInstance()
+	}
+	return fis
+}
+
+func routeFakes(fis []*fakeInstance) error {
+	requestChans := make([]<-chan *request, len(fis))
+	responseChans := make([]chan<- *response, len(fis))
+	for i, fi := range fis {
+		requestChans[i] = fi.requestChan
+		responseChans[i] = fi.responseChan
+	}
+	return RouteMessages(context.Background(), requestChans, responseChans, RouteOptions{})
+}
+
+// equivalentMessages returns true if the two messages are equal or differ in the SendTime only
+func equivalentMessages(a, b *Message) bool {
+	return a.Source == b.Source && a.Target == b.Target && bytes.Equal(a.Message, b.Message)
+}
+
+func TestRouterSimple(t *testing.T) {
+	fakes := setupFakes(2)
+	done := make(chan bool)
+	go func() {
+		if err := routeFakes(fakes); err != nil {
+			t.Errorf("RouteMessages unexcpectedly failed: %v", err)
+		}
+		close(done)
+	}()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		fakes[0].Send(1, []byte("foobar"))
+		fakes[0].Send(0, []byte("foobaz"))
+		if got, want := fakes[0].RecvFrom(0), (&Message{Source: 0, Target: 0, Message: []byte("foobaz")}); !equivalentMessages(got, want) {
+			t.Errorf("unexpected message received: got=%+v, want=%+v", got, want)
+		}
+		if got, want := fakes[0].Recv(), (&Message{Source: 1, Target: 0, Message: []byte("barbaz")}); !equivalentMessages(got, want) {
+			t.Errorf("unexpected message received: got=%+v, want=%+v", got, want)
+		}
+		fakes[0].Close()
+		wg.Done()
+	}()
+	wg.Add(1)
+	go func() {
+		fakes[1].Send(0, []byte("barbaz"))
+		fakes[1].Recv()
+		fakes[1].Close()
+		wg.Done()
+	}()
+	wg.Wait()
+	<-done
+}
+
+func TestMergeOrder(t *testing.T) {
+	// Each channel produces requests with the given timestamps and then closes.
+	times := [][]time.Duration{{1, 5, 6}, {2, 3, 9}, {}, {4, 5, 7, 8}}
+	inputs := make([]<-chan *request, len(times))
+	for i, ts := range times {
+		ch := make(chan *request, len(ts))
+		for _, t := range ts {
+			ch <- &request{requestType: requestSend, time: t, destination: i}
+		}
+		close(ch)
+		inputs[i] = ch
+	}
+	var got []time.Duration
+	var gotIDs []int
+	blocked, _ := merge(nil, inputs, nil, func(req *requestAndID) (int, bool) {
+		got = append(got, req.r.time)
+		gotIDs = append(gotIDs, req.id)
+		return req.id, false
+	})
+	if len(blocked) != 0 {
+		t.Errorf("unexpected blocked channels: %v", blocked)
+	}
+	want := []time.Duration{1, 2, 3, 4, 5, 5, 6, 7, 8, 9}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("requests merged in wrong order: got=%v, want=%v", got, want)
+	}
+	// Simultaneous requests are ordered by the channel index.
+	if gotIDs[4] != 0 || gotIDs[5] != 3 {
+		t.Errorf("simultaneous requests merged in wrong order: got channels %v", gotIDs)
+	}
+}
+
+func TestRouterCanceled(t *testing.T) {
+	fakes := setupFakes(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RouteMessages(ctx, []<-chan *request{fakes[0].requestChan, fakes[1].requestChan}, []chan<- *response{fakes[0].responseChan, fakes[1].responseChan}, RouteOptions{})
+	}()
+	// The router waits for instance 1, as it might still send a message to instance 0.
+	fakes[0].requestChan <- &request{requestType: requestRecv, time: 1, source: 1}
+	cancel()
+	// The requests are drained, but not routed anymore.
+	fakes[1].Send(0, []byte("foo"))
+	select {
+	case err := <-done:
+		t.Fatalf("RouteMessages returned before the instances have finished: %v", err)
+	default:
+	}
+	fakes[0].Close()
+	fakes[1].Close()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("wrong error from a canceled RouteMessages: got=%v, want=%v", err, context.Canceled)
+	}
+	select {
+	case resp := <-fakes[0].responseChan:
+		t.Errorf("a message was delivered after RouteMessages was canceled: %v", resp.message)
+	default:
+	}
+}
+
+func benchmarkRouter(b *testing.B, n int) {
+	// Every instance sends a message to the next one and receives a message from the previous one.
+	fakes := setupFakes(n)
+	done := make(chan error)
+	go func() {
+		done <- routeFakes(fakes)
+	}()
+	var wg sync.WaitGroup
+	for i, fi := range fakes {
+		wg.Add(1)
+		go func(i int, fi *fakeInstance) {
+			defer wg.Done()
+			for j := 0; j < b.N; j++ {
+				fi.Send((i+1)%n, []byte("foo"))
+				fi.RecvFrom((i + n - 1) % n)
+			}
+			fi.Close()
+		}(i, fi)
+	}
+	wg.Wait()
+	if err := <-done; err != nil {
+		b.Fatalf("RouteMessages unexpectedly failed: %v", err)
+	}
+}
+
+func BenchmarkRouter10(b *testing.B)   { benchmarkRouter(b, 10) }
+func BenchmarkRouter100(b *testing.B)  { benchmarkRouter(b, 100) }
+func BenchmarkRouter1000(b *testing.B) { benchmarkRouter(b, 1000) }
+
+// TODO: test the timestamp-ordering mechanism for receives
+// TODO: test deadlock detection (check for false positives too)
+// TODO: test remaining messages detection
@@ -0,0 +1,131 @@
+// Package runner runs a set of programs that communicate through the message passing
+// library used in Distributed Code Jam (zeus_local.c), routes the messages between them and
+// reports how much time each of them has spent computing and waiting for messages.
+//
+// It is what the parunner command is built on. A program that embeds it fills in Options
+// and calls Run:
+//
+//	cmds := make([]*exec.Cmd, 4)
+//	for i := range cmds {
+//		cmds[i] = exec.Command("./solution")
+//	}
+//	result, err := runner.Run(ctx, runner.Options{Commands: cmds})
+//
+// The binaries have to be linked with zeus_local.c from the zeus directory of parunner's
+// repository.
+//
+// Sandboxed instances (Options.Sandbox) and instances stopped at start
+// (InstanceOptions.StopAtStart) are started through a helper: the program that embeds this
+// package reexecutes itself (see os.Executable) with os.Args[0] set to
+// "parunner-sandbox-helper" or "parunner-stop-helper". The package's init functions
+// recognize these names, do the helper's work and never return to the program's main. A
+// program that imports this package therefore must not be run under these names for any
+// other purpose. Only the init functions of the packages initialized before this one run in
+// the helpers.
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// DefaultSpillThreshold is the total size of unreceived messages that is kept in memory
+// when Options.SpillThreshold is 0.
+const DefaultSpillThreshold = 256 * 1024 * 1024
+
+// Options describe a run of a set of instances.
+type Options struct {
+	// Commands are the commands of the instances; the instance IDs are the indices in
+	// Commands. Their standard input and outputs are used as set.
+	Commands []*exec.Cmd
+	// Funcs, if non-empty, are run as the instances instead of Commands, each in a goroutine
+	// of this process. Their CPU time is measured by a virtual clock (see NodeContext).
+	// Workers, Sandbox, SharedMemory and the CPUs of the instances don't apply to them.
+	Funcs []NodeFunc
+	// Instances, if non-nil, has the options of each of the instances. It must have the
+	// same length as Commands or Funcs.
+	Instances []InstanceOptions
+
+	// Observer, if non-nil, is notified of the events of the run, e.g. NewCommLog to trace
+	// the communication between the instances.
+	Observer Observer
+	// Chooser, if non-nil, decides which message is received when messages from several
+	// instances are waiting for a request to receive from any instance, e.g. a Schedule.
+	// Otherwise the choice is arbitrary.
+	Chooser Chooser
+	// Adversary, if non-nil, delays the messages before requests to receive from any
+	// instance can receive them, to make such requests receive them in unusual orders.
+	Adversary *Adversary
+	// MessageCountLimit and MessageSizeLimit limit the number and the total size in bytes
+	// of the messages sent by each instance. Zero means no limit.
+	MessageCountLimit int
+	MessageSizeLimit  int
+	// TimeLimit limits the simulated time of each instance, as Instance.TimeLimit does.
+	// Zero means no limit.
+	TimeLimit time.Duration
+	// MemoryLimit limits the memory of each instance, in bytes, as Instance.MemoryLimit does.
+	// Zero means no limit.
+	MemoryLimit int64
+	// SpillThreshold is the total size of unreceived messages kept in memory, in bytes.
+	// Messages above that are stored in temporary files. Zero means DefaultSpillThreshold.
+	SpillThreshold int64
+	// SharedMemory makes the instances receive message payloads through shared memory
+	// regions instead of their pipes (Linux only).
+	SharedMemory bool
+	// Sandbox makes each instance run in its own namespaces with a private working
+	// directory and a system call filter, which allows the instances to create threads but
+	// not processes (Linux only). The sandbox is set up by the running
+	// binary itself, reexecuted inside the namespaces, so it has to import this package.
+	Sandbox bool
+	// Parallelism, if positive, is the maximum number of instances that are allowed to
+	// compute at the same time. It only applies to instances run locally.
+	Parallelism int
+	// Workers, if non-empty, are the addresses of workers (see ServeWorker) that the
+	// instances are run on instead, in a round-robin fashion. WorkerToken is the secret
+	// that the workers require.
+	Workers     []string
+	WorkerToken string
+	// TimeScale is a factor that all the CPU times measured are multiplied by. Zero means 1.
+	TimeScale float64
+}
+
+// InstanceOptions are the options of a single instance.
+type InstanceOptions struct {
+	// Stdout and Stderr, if non-nil, receive the instance's output instead of the writers set
+	// in its command. They are closed after all the instances finish.
+	Stdout, Stderr OutputSink
+	// StopAtStart, TimeFromRequests and DetectToolReports are passed on to the Instance.
+	StopAtStart       bool
+	TimeFromRequests  bool
+	DetectToolReports bool
+	// Debugged marks an instance that is run under a debugger. It is neither sandboxed nor
+	// paused by the scheduler.
+	Debugged bool
+	// Speed is the speed of the instance relative to the others, e.g. 0.5 for an instance
+	// that should behave as if it was run on a machine twice as slow. Zero means 1.
+	Speed float64
+	// CPUs, if non-nil, are the CPUs that the instance is pinned to (Linux only).
+	CPUs []int
+}
+
+// Result describes a finished run.
+type Result struct {
+	Instances []*Instance
+	// RemainingMessages lists the pairs of instances that have messages between them that
+	// were left unreceived after all the instances have finished.
+	RemainingMessages []struct{ From, To int }
+}
+
+// Run runs the instances described by opts, as RunInstances does. If ctx is done before
+// the instances finish, all of them are killed and ErrCanceled is returned, together with
+// the Result. Messages left unreceived are reported in the Result instead of as an error.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	is, err := RunInstances(ctx, opts)
+	result := &Result{Instances: is}
+	if rm, ok := err.(ErrRemainingMessages); ok {
+		result.RemainingMessages = rm.RemainingMessages
+		err = nil
+	}
+	return result, err
+}
@@ -0,0 +1,21 @@
+package runner
+
+import "fmt"
+
+// sandboxHelperName is the name under which parunner reexecutes itself to set up the sandbox
+// from inside the new namespaces, right before it executes the instance's binary.
+const sandboxHelperName = "parunner-sandbox-helper"
+
+// sandboxTmpfsSize limits the size of the private working directory of a sandboxed instance.
+const sandboxTmpfsSize = 64 << 20
+
+// ErrSandboxViolation is returned when a sandboxed instance is killed for making a system call
+// that the sandbox doesn't allow.
+// It is usually encapsulated in an InstanceError that specifies the instance ID.
+type ErrSandboxViolation struct {
+	Err error
+}
+
+func (err ErrSandboxViolation) Error() string {
+	return fmt.Sprintf("sandbox violation (forbidden system call): %v", err.Err)
+}
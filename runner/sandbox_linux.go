@@ -0,0 +1,289 @@
+// +build linux,amd64 linux,arm64
+
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetNoNewPrivs    = 38
+	prSetSeccomp       = 22
+	seccompModeFilter  = 2
+	seccompRetKillProc = 0x80000000
+	seccompRetErrno    = 0x00050000
+	seccompRetAllow    = 0x7fff0000
+
+	// seccompArg0 is the offset of the low half of the first argument in struct seccomp_data
+	// on little-endian architectures. The arguments are 8 bytes apart.
+	seccompArg0    = 16
+	seccompArgSize = 8
+	// cloneNamespaces are the flags of clone that create new namespaces.
+	cloneNamespaces = 0x7e020000
+
+	// These are the same on all architectures, but missing from package syscall on some.
+	oPath       = 0x200000
+	atEmptyPath = 0x1000
+)
+
+func init() {
+	// The helper has to run before anything else starts, so that the thread that installs
+	// the system call filter is the one that executes the instance's binary.
+	if len(os.Args) > 0 && os.Args[0] == sandboxHelperName {
+		runtime.LockOSThread()
+		err := sandboxMain(os.Args[1:])
+		fmt.Fprintf(os.Stderr, "parunner sandbox: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// sandboxCommand modifies cmd so that it runs the same program inside a sandbox. It returns
+// the path of a directory that should be removed once the command finishes.
+func sandboxCommand(cmd *exec.Cmd) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	path := cmd.Path
+	if !filepath.IsAbs(path) {
+		if path, err = filepath.Abs(filepath.Join(cmd.Dir, path)); err != nil {
+			return "", err
+		}
+	}
+	workdir, err := ioutil.TempDir("", "parunner-sandbox")
+	if err != nil {
+		return "", err
+	}
+	cmd.Args = append([]string{sandboxHelperName, workdir, path}, cmd.Args...)
+	cmd.Path = self
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	// The helper needs to be root in its user namespace to set up the mounts.
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	return workdir, nil
+}
+
+// sandboxError converts the error of a sandboxed command that was killed by the system
+// call filter into ErrSandboxViolation.
+func sandboxError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGSYS {
+			return ErrSandboxViolation{err}
+		}
+	}
+	return err
+}
+
+// sandboxMain runs in the helper process, inside the new namespaces. The arguments are the
+// working directory, the path of the binary and its arguments. It returns only on error.
+func sandboxMain(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("expected at least 3 arguments, got %d", len(args))
+	}
+	workdir, path, argv := args[0], args[1], args[2:]
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return os.NewSyscallError("mount", err)
+	}
+	if err := remountReadOnly(); err != nil {
+		return err
+	}
+	// The /proc inherited from the parent namespace would show the processes outside the
+	// sandbox and not the instance's own.
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC|syscall.MS_RDONLY, ""); err != nil {
+		return os.NewSyscallError("mount", fmt.Errorf("mounting /proc: %v", err))
+	}
+	if err := syscall.Mount("tmpfs", workdir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, fmt.Sprintf("size=%d,mode=0700", sandboxTmpfsSize)); err != nil {
+		return os.NewSyscallError("mount", err)
+	}
+	if err := os.Chdir(workdir); err != nil {
+		return err
+	}
+	// The binary is executed with execveat on a descriptor, whose number and empty path are
+	// fixed in the system call filter, so that the binary can't execute anything else.
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	argvp, err := syscall.SlicePtrFromStrings(argv)
+	if err != nil {
+		return err
+	}
+	envvp, err := syscall.SlicePtrFromStrings(os.Environ())
+	if err != nil {
+		return err
+	}
+	emptyPath := new(byte)
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return os.NewSyscallError("prctl", errno)
+	}
+	filter := seccompFilter(fd, emptyPath)
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return os.NewSyscallError("prctl", errno)
+	}
+	_, _, errno := syscall.RawSyscall6(sysExecveat, uintptr(fd), uintptr(unsafe.Pointer(emptyPath)), uintptr(unsafe.Pointer(&argvp[0])), uintptr(unsafe.Pointer(&envvp[0])), atEmptyPath, 0)
+	return os.NewSyscallError("execveat", errno)
+}
+
+// remountReadOnly makes all the mounts visible in the mount namespace read-only, so that
+// instances can't share state through the filesystem.
+func remountReadOnly() error {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Flags that are locked on mounts inherited from a more privileged namespace, which
+	// we have to preserve when remounting.
+	lockedFlags := map[string]uintptr{
+		"nosuid":      syscall.MS_NOSUID,
+		"nodev":       syscall.MS_NODEV,
+		"noexec":      syscall.MS_NOEXEC,
+		"noatime":     syscall.MS_NOATIME,
+		"nodiratime":  syscall.MS_NODIRATIME,
+		"relatime":    syscall.MS_RELATIME,
+		"strictatime": syscall.MS_STRICTATIME,
+	}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// See proc(5) for the format of mountinfo.
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mountPoint, err := unescapeMountPoint(fields[4])
+		if err != nil {
+			return err
+		}
+		flags := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY)
+		for _, opt := range strings.Split(fields[5], ",") {
+			flags |= lockedFlags[opt]
+		}
+		if err := syscall.Mount("", mountPoint, "", flags, ""); err != nil {
+			// Some mounts (e.g. under /proc) can't be remounted in a user namespace. They
+			// are not writable by instances anyway.
+			if err == syscall.EPERM || err == syscall.EACCES {
+				continue
+			}
+			return os.NewSyscallError("mount", fmt.Errorf("remounting %s: %v", mountPoint, err))
+		}
+	}
+	return s.Err()
+}
+
+// unescapeMountPoint undoes the octal escaping of whitespace and backslashes in mountinfo.
+func unescapeMountPoint(s string) (string, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			c, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid mount point %q", s)
+			}
+			out = append(out, byte(c))
+			i += 3
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return string(out), nil
+}
+
+// seccompFilter returns a BPF program that allows the system calls from allowedSyscalls,
+// clone for creating threads and a single execveat, of the descriptor fd with the empty path
+// at address emptyPath, and kills the process on any other. clone3 fails with ENOSYS instead,
+// as its arguments can't be checked, so that the C library falls back to clone.
+//
+// The execveat is the one the helper executes the instance's binary with. The descriptor is
+// closed on exec and the address belongs to the helper's memory, which the binary replaces,
+// so the binary can only execute another program if it maps memory at that very address and
+// opens the program under that very descriptor.
+func seccompFilter(fd int, emptyPath *byte) []syscall.SockFilter {
+	stmt := func(code uint16, k uint32) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+	// The filter operates on struct seccomp_data, which starts with the system call number
+	// followed by the architecture.
+	filter := []syscall.SockFilter{
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 4),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, auditArch, 1, 0),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProc),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 0),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, sysClone3, 0, 1),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.ENOSYS)),
+		// A clone that creates a thread (CLONE_THREAD, which in turn requires the memory to
+		// be shared) in the current namespaces is allowed.
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.SYS_CLONE, 0, 5),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompArg0),
+		jump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, syscall.CLONE_THREAD, 0, 2),
+		jump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, cloneNamespaces, 1, 0),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProc),
+		// The execveat that starts the binary: execveat(fd, emptyPath, argv, envp, AT_EMPTY_PATH).
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, sysExecveat, 0, 10),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompArg0),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(fd), 0, 7),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompArg0+seccompArgSize),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(uintptr(unsafe.Pointer(emptyPath))), 0, 5),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompArg0+seccompArgSize+4),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(uint64(uintptr(unsafe.Pointer(emptyPath)))>>32), 0, 3),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompArg0+4*seccompArgSize),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, atEmptyPath, 0, 1),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProc),
+	}
+	for _, nr := range allowedSyscalls {
+		filter = append(filter,
+			jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(nr), 0, 1),
+			stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow))
+	}
+	return append(filter, stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProc))
+}
+
+// commonSyscalls are the system calls needed for computation, memory management, I/O on
+// already open descriptors (including the communication pipes) and on the private working
+// directory. Notably missing are the ones that create processes, execute programs, create
+// sockets or IPC objects and the ones that affect other processes. Threads are created with
+// clone, and the instance's binary is started with execveat, which seccompFilter checks
+// separately.
+var commonSyscalls = []int{
+	syscall.SYS_READ, syscall.SYS_WRITE, syscall.SYS_READV, syscall.SYS_WRITEV,
+	syscall.SYS_PREAD64, syscall.SYS_PWRITE64, syscall.SYS_CLOSE, syscall.SYS_FSTAT,
+	syscall.SYS_LSEEK, syscall.SYS_FCNTL, syscall.SYS_IOCTL, syscall.SYS_DUP, syscall.SYS_DUP3,
+	syscall.SYS_PIPE2, syscall.SYS_PPOLL, syscall.SYS_PSELECT6, syscall.SYS_FADVISE64,
+	syscall.SYS_OPENAT, syscall.SYS_READLINKAT, syscall.SYS_FACCESSAT, syscall.SYS_GETCWD,
+	syscall.SYS_GETDENTS64, syscall.SYS_MKDIRAT, syscall.SYS_UNLINKAT, syscall.SYS_FTRUNCATE,
+	syscall.SYS_FSYNC, syscall.SYS_FDATASYNC,
+	syscall.SYS_MMAP, syscall.SYS_MUNMAP, syscall.SYS_MPROTECT, syscall.SYS_MREMAP,
+	syscall.SYS_MADVISE, syscall.SYS_BRK,
+	syscall.SYS_RT_SIGACTION, syscall.SYS_RT_SIGPROCMASK, syscall.SYS_RT_SIGRETURN,
+	syscall.SYS_SIGALTSTACK, syscall.SYS_RT_SIGSUSPEND, syscall.SYS_RESTART_SYSCALL, syscall.SYS_TGKILL,
+	syscall.SYS_CLOCK_GETTIME, syscall.SYS_CLOCK_GETRES, syscall.SYS_GETTIMEOFDAY,
+	syscall.SYS_TIMES, syscall.SYS_GETRUSAGE, syscall.SYS_NANOSLEEP, syscall.SYS_CLOCK_NANOSLEEP,
+	syscall.SYS_FUTEX, syscall.SYS_SET_TID_ADDRESS, syscall.SYS_SET_ROBUST_LIST,
+	syscall.SYS_SCHED_YIELD, syscall.SYS_SCHED_GETAFFINITY,
+	syscall.SYS_PRLIMIT64, syscall.SYS_GETRLIMIT, syscall.SYS_SETRLIMIT, syscall.SYS_SYSINFO,
+	syscall.SYS_UNAME, syscall.SYS_GETPID, syscall.SYS_GETPPID, syscall.SYS_GETTID, syscall.SYS_GETUID,
+	syscall.SYS_GETEUID, syscall.SYS_GETGID, syscall.SYS_GETEGID,
+	syscall.SYS_EXIT, syscall.SYS_EXIT_GROUP,
+	sysFaccessat2,
+}
+
+var allowedSyscalls = append(commonSyscalls, archSyscalls...)
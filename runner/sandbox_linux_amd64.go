@@ -0,0 +1,22 @@
+package runner
+
+import "syscall"
+
+const auditArch = 0xc000003e // AUDIT_ARCH_X86_64
+
+const (
+	sysGetrandom  = 318
+	sysExecveat   = 322
+	sysStatx      = 332
+	sysRseq       = 334
+	sysClone3     = 435
+	sysFaccessat2 = 439
+)
+
+var archSyscalls = []int{
+	syscall.SYS_OPEN, syscall.SYS_STAT, syscall.SYS_LSTAT, syscall.SYS_NEWFSTATAT,
+	syscall.SYS_ACCESS, syscall.SYS_READLINK, syscall.SYS_DUP2, syscall.SYS_PIPE,
+	syscall.SYS_POLL, syscall.SYS_SELECT, syscall.SYS_GETDENTS, syscall.SYS_MKDIR,
+	syscall.SYS_UNLINK, syscall.SYS_ARCH_PRCTL, syscall.SYS_TIME, syscall.SYS_PAUSE,
+	sysGetrandom, sysStatx, sysRseq,
+}
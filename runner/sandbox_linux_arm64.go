@@ -0,0 +1,17 @@
+package runner
+
+import "syscall"
+
+const auditArch = 0xc00000b7 // AUDIT_ARCH_AARCH64
+
+const (
+	sysExecveat   = 281
+	sysStatx      = 291
+	sysRseq       = 293
+	sysClone3     = 435
+	sysFaccessat2 = 439
+)
+
+var archSyscalls = []int{
+	syscall.SYS_FSTATAT, syscall.SYS_GETRANDOM, sysStatx, sysRseq,
+}
@@ -0,0 +1,123 @@
+// +build linux,amd64 linux,arm64
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// skipWithoutSandbox skips the test if the sandbox can't be set up, e.g. because user
+// namespaces are disabled.
+func skipWithoutSandbox(t *testing.T) {
+	cmd := exec.Command("/bin/true")
+	workdir, err := sandboxCommand(cmd)
+	if err != nil {
+		t.Skipf("sandbox not available: %v", err)
+	}
+	defer (&localProcess{workdir: workdir}).removeWorkdir()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("sandbox not available: %v (%s)", err, out)
+	}
+}
+
+func runSandboxed(t *testing.T, cmds []*exec.Cmd) error {
+	_, err := RunInstances(context.Background(), Options{Commands: cmds, Sandbox: true})
+	return err
+}
+
+func TestInstancesSandbox(t *testing.T) {
+	skipWithoutSandbox(t)
+	var outputs [3]bytes.Buffer
+	cmds := make([]*exec.Cmd, 3)
+	for i, input := range []string{"Rb\nRb\nRc\n", "Safoo\nSabarbaz\n", "Sa\nScblah\nRc\n"} {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+		cmds[i].Stdout = &outputs[i]
+	}
+	if err := runSandboxed(t, cmds); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	for i, want := range []string{"0 3\n1 3 foo\n1 6 barbaz\n2 0 \n", "1 3\n", "2 3\n2 4 blah\n"} {
+		if got := outputs[i].String(); got != want {
+			t.Errorf("wrong output from instance %d: got=%q, want=%q", i, got, want)
+		}
+	}
+}
+
+func TestInstancesSandboxFiles(t *testing.T) {
+	skipWithoutSandbox(t)
+	var output bytes.Buffer
+	// The working directory is writable, but nothing else is.
+	cmd := exec.Command("/bin/sh", "-c", "echo foo > bar && read x < bar && echo $x && ! { echo foo > /tmp/parunner-sandbox-test; } 2>/dev/null")
+	cmd.Stdout = &output
+	if err := runSandboxed(t, []*exec.Cmd{cmd}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if got, want := output.String(), "foo\n"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+}
+
+func TestInstancesSandboxThreads(t *testing.T) {
+	skipWithoutSandbox(t)
+	// The Go runtime starts several threads.
+	var output bytes.Buffer
+	cmd := exec.Command(testerPath)
+	cmd.Stdin = strings.NewReader("C\n")
+	cmd.Stdout = &output
+	if err := runSandboxed(t, []*exec.Cmd{cmd}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if got, want := output.String(), "0 1\n"; got != want {
+		t.Errorf("wrong output: got=%q, want=%q", got, want)
+	}
+}
+
+func TestInstancesSandboxProc(t *testing.T) {
+	skipWithoutSandbox(t)
+	// The instance is the first process of its PID namespace, and /proc should show that.
+	var output bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", "read -r pid rest < /proc/self/stat && echo $pid")
+	cmd.Stdout = &output
+	if err := runSandboxed(t, []*exec.Cmd{cmd}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if got, want := output.String(), "1\n"; got != want {
+		t.Errorf("wrong PID in /proc/self/stat: got=%q, want=%q", got, want)
+	}
+}
+
+func TestInstancesSandboxViolation(t *testing.T) {
+	skipWithoutSandbox(t)
+	// A pipeline makes the shell fork, which is not allowed.
+	cmds := []*exec.Cmd{exec.Command(testerPath), exec.Command("/bin/sh", "-c", "true | true")}
+	cmds[0].Stdin = strings.NewReader("Rb\n")
+	err := runSandboxed(t, cmds)
+	ie, ok := err.(InstanceError)
+	if !ok {
+		t.Fatalf("expected an InstanceError, got %v", err)
+	}
+	if ie.ID != 1 {
+		t.Errorf("wrong instance ID in error: got=%d, want=1", ie.ID)
+	}
+	if _, ok := ie.Err.(ErrSandboxViolation); !ok {
+		t.Errorf("expected ErrSandboxViolation, got %v", ie.Err)
+	}
+}
+
+func TestInstancesSandboxExec(t *testing.T) {
+	skipWithoutSandbox(t)
+	// Only the helper's execution of the instance's binary is allowed.
+	err := runSandboxed(t, []*exec.Cmd{exec.Command("/bin/sh", "-c", "exec /bin/true")})
+	ie, ok := err.(InstanceError)
+	if !ok {
+		t.Fatalf("expected an InstanceError, got %v", err)
+	}
+	if _, ok := ie.Err.(ErrSandboxViolation); !ok {
+		t.Errorf("expected ErrSandboxViolation, got %v", ie.Err)
+	}
+}
@@ -0,0 +1,16 @@
+// +build !linux linux,!amd64,!arm64
+
+package runner
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func sandboxCommand(cmd *exec.Cmd) (string, error) {
+	return "", errors.New("sandbox is not supported on this platform")
+}
+
+func sandboxError(err error) error {
+	return err
+}
@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// A Scheduler limits the number of instances that compute at the same time. An instance
+// needs a slot to compute. It gives its slot up when it waits for a message and when it
+// is paused. Instances waiting for a slot get it in the order of their simulated time, so
+// that the simulation advances evenly.
+type Scheduler struct {
+	mu      sync.Mutex
+	free    int
+	waiting waiterHeap
+	seq     int
+}
+
+// NewScheduler creates a Scheduler that lets k instances compute at the same time.
+func NewScheduler(k int) *Scheduler {
+	return &Scheduler{free: k}
+}
+
+type waiter struct {
+	time time.Duration
+	// seq orders waiters with equal times by their arrival.
+	seq     int
+	index   int
+	granted chan bool
+}
+
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int { return len(h) }
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].time != h[j].time {
+		return h[i].time < h[j].time
+	}
+	return h[i].seq < h[j].seq
+}
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *waiterHeap) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	*h = old[:len(old)-1]
+	w.index = -1
+	return w
+}
+
+// TryAcquire takes a slot if one is free and nobody is waiting for it.
+func (s *Scheduler) TryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.free > 0 && s.waiting.Len() == 0 {
+		s.free--
+		return true
+	}
+	return false
+}
+
+// Acquire waits for a slot for an instance at simulated time t. It returns false without
+// taking a slot if cancel is closed first.
+func (s *Scheduler) Acquire(t time.Duration, cancel <-chan bool) bool {
+	s.mu.Lock()
+	if s.free > 0 && s.waiting.Len() == 0 {
+		s.free--
+		s.mu.Unlock()
+		return true
+	}
+	w := &waiter{time: t, seq: s.seq, granted: make(chan bool)}
+	s.seq++
+	heap.Push(&s.waiting, w)
+	s.mu.Unlock()
+	select {
+	case <-w.granted:
+		return true
+	case <-cancel:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.index == -1 {
+		// The slot was handed to us in the meantime.
+		s.releaseLocked()
+	} else {
+		heap.Remove(&s.waiting, w.index)
+	}
+	return false
+}
+
+// Release gives a slot up, handing it to the first waiting instance, if any.
+func (s *Scheduler) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *Scheduler) releaseLocked() {
+	if s.waiting.Len() == 0 {
+		s.free++
+		return
+	}
+	w := heap.Pop(&s.waiting).(*waiter)
+	close(w.granted)
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	s := NewScheduler(1)
+	if !s.TryAcquire() {
+		t.Fatalf("TryAcquire failed on a free scheduler")
+	}
+	if s.TryAcquire() {
+		t.Fatalf("TryAcquire succeeded on a full scheduler")
+	}
+	order := make(chan time.Duration, 3)
+	for _, tm := range []time.Duration{3, 1, 2} {
+		go func(tm time.Duration) {
+			if s.Acquire(tm, nil) {
+				order <- tm
+			}
+		}(tm)
+	}
+	// Wait until all the goroutines are waiting for the slot.
+	for {
+		s.mu.Lock()
+		n := s.waiting.Len()
+		s.mu.Unlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, want := range []time.Duration{1, 2, 3} {
+		s.Release()
+		if got := <-order; got != want {
+			t.Errorf("slot given in wrong order: got=%v, want=%v", got, want)
+		}
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := NewScheduler(1)
+	s.TryAcquire()
+	cancel := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		done <- s.Acquire(0, cancel)
+	}()
+	close(cancel)
+	if <-done {
+		t.Errorf("a cancelled Acquire has taken a slot")
+	}
+	s.Release()
+	if !s.TryAcquire() {
+		t.Errorf("the slot was lost after a cancelled Acquire")
+	}
+}
+
+func TestInstancesParallelism(t *testing.T) {
+	// Instance 0 sends many messages to instance 2, which starts receiving them only after
+	// it gets a message from instance 1, so the router has to wait for the paused instances.
+	var sends string
+	for i := 0; i < 500; i++ {
+		sends += "Scfoo\n"
+	}
+	inputs := []string{"C\n" + sends, "C\nScbar\n", "Rb\n" + strings.Repeat("Ra\n", 500)}
+	var outputs [3]strings.Builder
+	cmds := make([]*exec.Cmd, len(inputs))
+	for i, input := range inputs {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+		cmds[i].Stdout = &outputs[i]
+	}
+	if _, err := RunInstances(context.Background(), Options{Commands: cmds, Parallelism: 1}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	want := "2 3\n1 3 bar\n" + strings.Repeat("0 3 foo\n", 500)
+	if got := outputs[2].String(); got != want {
+		t.Errorf("wrong output from instance 2: got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/robryk/parunner/wire"
+)
+
+// Layout of the shared memory region of an instance. It must be kept in sync with zeus_local.c.
+//
+// The send ring is written by the instance and read by parunner. The instance advances head
+// after placing a payload in the ring and parunner advances tail after copying it out. The
+// receive area holds the payload of the most recent response; the instance copies it out
+// before issuing its next request.
+const (
+	shmHeadOffset  = 0  // uint64: number of bytes ever written to the send ring
+	shmTailOffset  = 8  // uint64: number of bytes ever consumed from the send ring
+	shmReadyOffset = 16 // uint32: set by the instance once it has mapped the region
+	shmRingOffset  = 64
+	shmRingSize    = wire.MaxMessageSize
+	shmRecvOffset  = shmRingOffset + shmRingSize
+	shmSize        = shmRecvOffset + wire.MaxMessageSize
+)
+
+// shmFdEnv is the environment variable that tells zeus_local.c which file descriptor holds
+// the shared memory region.
+const shmFdEnv = "ZEUS_SHM_FD"
+
+// A sharedRegion is the parunner's side of an instance's shared memory region.
+type sharedRegion struct {
+	// File is passed to the instance.
+	File *os.File
+	mem  []byte
+	tail uint64
+	// unmap releases mem. It is nil if mem is not a mapping.
+	unmap func([]byte) error
+}
+
+func (sr *sharedRegion) word64(offset int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&sr.mem[offset]))
+}
+
+// ready returns true iff the instance has mapped the region, so that responses can be
+// passed through it.
+func (sr *sharedRegion) ready() bool {
+	return atomic.LoadUint32((*uint32)(unsafe.Pointer(&sr.mem[shmReadyOffset]))) != 0
+}
+
+// sendPayload returns a reader for the next length bytes of the send ring. Once the payload
+// is read, consume must be called.
+func (sr *sharedRegion) sendPayload(length int) (io.Reader, error) {
+	if head := atomic.LoadUint64(sr.word64(shmHeadOffset)); head-sr.tail < uint64(length) || head-sr.tail > shmRingSize {
+		return nil, fmt.Errorf("shared memory send of %d bytes, but only %d bytes are in the ring", length, head-sr.tail)
+	}
+	start := int(sr.tail % shmRingSize)
+	ring := sr.mem[shmRingOffset : shmRingOffset+shmRingSize]
+	if start+length <= shmRingSize {
+		return bytes.NewReader(ring[start : start+length]), nil
+	}
+	return io.MultiReader(bytes.NewReader(ring[start:]), bytes.NewReader(ring[:start+length-shmRingSize])), nil
+}
+
+func (sr *sharedRegion) consume(length int) {
+	sr.tail += uint64(length)
+	atomic.StoreUint64(sr.word64(shmTailOffset), sr.tail)
+}
+
+// putResponse copies a payload of a response to the receive area.
+func (sr *sharedRegion) putResponse(r io.Reader, length int) error {
+	if length > wire.MaxMessageSize {
+		return fmt.Errorf("message of %d bytes doesn't fit in shared memory", length)
+	}
+	_, err := io.ReadFull(r, sr.mem[shmRecvOffset:shmRecvOffset+length])
+	return err
+}
+
+// Release unmaps the region and closes its file.
+func (sr *sharedRegion) Release() error {
+	var err error
+	if sr.unmap != nil {
+		err = sr.unmap(sr.mem)
+	}
+	sr.mem = nil
+	if sr.File != nil {
+		if err1 := sr.File.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+// +build linux,amd64 linux,arm64
+
+package runner
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const mfdCloexec = 1
+
+func newSharedRegion() (*sharedRegion, error) {
+	name, err := syscall.BytePtrFromString("parunner-shm")
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(name)), mfdCloexec, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("memfd_create", errno)
+	}
+	f := os.NewFile(fd, "parunner-shm")
+	if err := f.Truncate(shmSize); err != nil {
+		f.Close()
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(fd), 0, shmSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	return &sharedRegion{File: f, mem: mem, unmap: syscall.Munmap}, nil
+}
@@ -0,0 +1,3 @@
+package runner
+
+const sysMemfdCreate = 319
@@ -0,0 +1,29 @@
+// +build linux,amd64 linux,arm64
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestInstancesSharedMemory(t *testing.T) {
+	var outputs [3]bytes.Buffer
+	cmds := make([]*exec.Cmd, 3)
+	for i, input := range []string{"Rb\nRb\nRc\n", "Safoo\nSabarbaz\n", "Sa\nScblah\nRc\n"} {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+		cmds[i].Stdout = &outputs[i]
+	}
+	if _, err := RunInstances(context.Background(), Options{Commands: cmds, SharedMemory: true}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	for i, want := range []string{"0 3\n1 3 foo\n1 6 barbaz\n2 0 \n", "1 3\n", "2 3\n2 4 blah\n"} {
+		if got := strings.Replace(outputs[i].String(), "\r\n", "\n", -1); got != want {
+			t.Errorf("wrong output from instance %d: got=%q, want=%q", i, got, want)
+		}
+	}
+}
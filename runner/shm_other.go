@@ -0,0 +1,9 @@
+// +build !linux linux,!amd64,!arm64
+
+package runner
+
+import "errors"
+
+func newSharedRegion() (*sharedRegion, error) {
+	return nil, errors.New("shared memory transport is not supported on this platform")
+}
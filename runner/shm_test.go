@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSharedRegionRing(t *testing.T) {
+	sr := &sharedRegion{mem: make([]byte, shmSize)}
+	// Simulate an instance that has already sent almost a full ring of data, so that
+	// the next payload wraps around.
+	const offset = shmRingSize - 3
+	sr.tail = offset
+	payload := "foobar"
+	for i := range payload {
+		sr.mem[shmRingOffset+(offset+i)%shmRingSize] = payload[i]
+	}
+	binary.LittleEndian.PutUint64(sr.mem[shmHeadOffset:], offset+uint64(len(payload)))
+
+	if _, err := sr.sendPayload(len(payload) + 1); err == nil {
+		t.Errorf("no error when reading more than the instance has placed in the ring")
+	}
+	r, err := sr.sendPayload(len(payload))
+	if err != nil {
+		t.Fatalf("error reading a payload from the ring: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading a payload from the ring: %v", err)
+	}
+	if string(got) != payload {
+		t.Errorf("wrong payload read from the ring: got=%q, want=%q", got, payload)
+	}
+	sr.consume(len(payload))
+	if got, want := binary.LittleEndian.Uint64(sr.mem[shmTailOffset:]), uint64(offset+len(payload)); got != want {
+		t.Errorf("wrong tail after consuming a payload: got=%d, want=%d", got, want)
+	}
+}
+
+func TestSharedRegionResponse(t *testing.T) {
+	sr := &sharedRegion{mem: make([]byte, shmSize)}
+	if sr.ready() {
+		t.Errorf("a fresh region claims that the instance has mapped it")
+	}
+	if err := sr.putResponse(strings.NewReader("foobaz"), 6); err != nil {
+		t.Fatalf("error placing a response in the region: %v", err)
+	}
+	if got := sr.mem[shmRecvOffset : shmRecvOffset+6]; !bytes.Equal(got, []byte("foobaz")) {
+		t.Errorf("wrong response payload in the region: got=%q", got)
+	}
+}
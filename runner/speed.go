@@ -0,0 +1,11 @@
+package runner
+
+import "time"
+
+// scaleTime multiplies a CPU time by scale, treating a zero scale as 1.
+func scaleTime(t time.Duration, scale float64) time.Duration {
+	if scale == 0 {
+		return t
+	}
+	return time.Duration(float64(t) * scale)
+}
@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstancesSpeed(t *testing.T) {
+	// Instance 0 is so slow that its message arrives long after instance 1 starts to wait for it.
+	inputs := []string{"C\nSbfoo\n", "Ra\n"}
+	cmds := make([]*exec.Cmd, len(inputs))
+	for i, input := range inputs {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+	}
+	instances, err := RunInstances(context.Background(), Options{Commands: cmds, Instances: []InstanceOptions{{Speed: 0.001}, {}}})
+	if err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if slow, fast := instances[0].TimeRunning, instances[1].TimeRunning; slow < 100*fast {
+		t.Errorf("the slow instance's time isn't scaled: got %v, while the other instance's is %v", slow, fast)
+	}
+	// The computation takes a few milliseconds, so it should take seconds when scaled.
+	if blocked := instances[1].TimeBlocked; blocked < time.Second {
+		t.Errorf("instance 1 has waited only for %v for a message from the slow instance", blocked)
+	}
+}
@@ -0,0 +1,156 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// A MessageStore holds the payloads of messages that were sent, but not received yet.
+// Payloads are kept in memory as long as their total size doesn't exceed the threshold.
+// Payloads that don't fit are spilled to temporary files, that are created on demand.
+// Each file holds payloads up to a segment size, and is removed once all of them are
+// released, so that a single payload that is never received can't hold on to the space
+// of all the payloads spilled after it.
+type MessageStore struct {
+	threshold   int64
+	segmentSize int64
+
+	mu       sync.Mutex
+	inMemory int64
+	current  *spillSegment              // the segment that new payloads are appended to
+	segments map[*spillSegment]struct{} // all the segments whose files exist
+	spilled  int                        // number of unreleased payloads in the files
+}
+
+// A spillSegment is one of a MessageStore's files.
+type spillSegment struct {
+	f    *os.File
+	size int64 // end of the file's used area
+	live int   // number of unreleased payloads in the file
+}
+
+// spillSegmentSize is the size above which a MessageStore starts a new file.
+const spillSegmentSize = 16 << 20
+
+// StoredPayload is a message payload that was spilled to a MessageStore's file.
+type StoredPayload struct {
+	store   *MessageStore
+	segment *spillSegment
+	offset  int64
+	length  int64
+}
+
+// NewMessageStore creates a MessageStore that keeps at most threshold bytes of payloads in memory.
+func NewMessageStore(threshold int64) *MessageStore {
+	return &MessageStore{
+		threshold:   threshold,
+		segmentSize: spillSegmentSize,
+		segments:    make(map[*spillSegment]struct{}),
+	}
+}
+
+// Store reads a payload of a given length from r. It returns either the payload itself,
+// or a handle to its copy in one of the store's files.
+func (ms *MessageStore) Store(r io.Reader, length int) ([]byte, *StoredPayload, error) {
+	ms.mu.Lock()
+	if ms.inMemory+int64(length) <= ms.threshold {
+		ms.inMemory += int64(length)
+		ms.mu.Unlock()
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+			ms.ReleaseMemory(length)
+			return nil, nil, err
+		}
+		return buf.Bytes(), nil, nil
+	}
+	if ms.current == nil || ms.current.size >= ms.segmentSize {
+		f, err := ioutil.TempFile("", "msgstore")
+		if err != nil {
+			ms.mu.Unlock()
+			return nil, nil, err
+		}
+		// The previous segment, if any, still has live payloads, as otherwise it would have
+		// been emptied. It is removed once they are released.
+		ms.current = &spillSegment{f: f}
+		ms.segments[ms.current] = struct{}{}
+	}
+	seg := ms.current
+	sp := &StoredPayload{store: ms, segment: seg, offset: seg.size, length: int64(length)}
+	seg.size += int64(length)
+	seg.live++
+	ms.spilled++
+	ms.mu.Unlock()
+	if _, err := io.CopyN(io.NewOffsetWriter(seg.f, sp.offset), r, sp.length); err != nil {
+		sp.Release()
+		return nil, nil, err
+	}
+	return nil, sp, nil
+}
+
+func (ms *MessageStore) releaseSpilled(seg *spillSegment) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.spilled--
+	seg.live--
+	if seg.live > 0 || seg.f == nil {
+		return
+	}
+	if seg == ms.current {
+		// Nothing in the file is needed anymore, so we can reuse it from the start.
+		seg.f.Truncate(0)
+		seg.size = 0
+		return
+	}
+	seg.remove()
+	delete(ms.segments, seg)
+}
+
+func (seg *spillSegment) remove() error {
+	filename := seg.f.Name()
+	err := seg.f.Close()
+	if err1 := os.Remove(filename); err == nil {
+		err = err1
+	}
+	seg.f = nil
+	return err
+}
+
+// ReleaseMemory marks an in-memory payload of a given length as no longer held.
+func (ms *MessageStore) ReleaseMemory(length int) {
+	ms.mu.Lock()
+	ms.inMemory -= int64(length)
+	ms.mu.Unlock()
+}
+
+// Close removes the store's files. No payloads may be read after a call to Close.
+func (ms *MessageStore) Close() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var err error
+	for seg := range ms.segments {
+		if err1 := seg.remove(); err == nil {
+			err = err1
+		}
+	}
+	ms.segments = make(map[*spillSegment]struct{})
+	ms.current = nil
+	return err
+}
+
+// Len returns the length of the payload.
+func (sp *StoredPayload) Len() int {
+	return int(sp.length)
+}
+
+// Reader returns a reader of the payload's contents.
+func (sp *StoredPayload) Reader() io.Reader {
+	return io.NewSectionReader(sp.segment.f, sp.offset, sp.length)
+}
+
+// Release frees the payload's space in the store. The payload must not be read afterwards.
+func (sp *StoredPayload) Release() {
+	sp.store.releaseSpilled(sp.segment)
+}
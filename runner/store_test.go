@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestMessageStore(t *testing.T) {
+	ms := NewMessageStore(10)
+	defer ms.Close()
+	small, sp, err := ms.Store(strings.NewReader("foobar"), 6)
+	if err != nil {
+		t.Fatalf("error storing a payload: %v", err)
+	}
+	if sp != nil || string(small) != "foobar" {
+		t.Errorf("a payload below the threshold was not kept in memory: got %q, %v", small, sp)
+	}
+	var spilled []*StoredPayload
+	for _, s := range []string{"bazbl", "abcdefghijklmnopqrstuvwxyz"} {
+		buf, sp, err := ms.Store(strings.NewReader(s), len(s))
+		if err != nil {
+			t.Fatalf("error storing a payload: %v", err)
+		}
+		if sp == nil {
+			t.Fatalf("a payload of %d bytes above the threshold was kept in memory (%q)", len(s), buf)
+		}
+		spilled = append(spilled, sp)
+	}
+	for i, want := range []string{"bazbl", "abcdefghijklmnopqrstuvwxyz"} {
+		got, err := ioutil.ReadAll(spilled[i].Reader())
+		if err != nil {
+			t.Fatalf("error reading a spilled payload: %v", err)
+		}
+		if string(got) != want || spilled[i].Len() != len(want) {
+			t.Errorf("wrong spilled payload: got=%q (length %d), want=%q", got, spilled[i].Len(), want)
+		}
+	}
+	ms.ReleaseMemory(len(small))
+	if buf, sp, err := ms.Store(strings.NewReader("0123456789"), 10); err != nil || sp != nil || string(buf) != "0123456789" {
+		t.Errorf("a payload that fits after a release was not kept in memory: got %q, %v, %v", buf, sp, err)
+	}
+	for _, sp := range spilled {
+		sp.Release()
+	}
+	if fi, err := ms.current.f.Stat(); err != nil || fi.Size() != 0 {
+		t.Errorf("store's file was not emptied after releasing all payloads: %v, %v", fi, err)
+	}
+	filename := ms.current.f.Name()
+	if err := ms.Close(); err != nil {
+		t.Fatalf("error closing a store: %v", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("store's file %s still exists after Close: %v", filename, err)
+	}
+}
+
+func TestMessageStoreTruncated(t *testing.T) {
+	ms := NewMessageStore(0)
+	defer ms.Close()
+	if _, _, err := ms.Store(strings.NewReader("foo"), 6); err == nil {
+		t.Errorf("no error when storing a truncated payload")
+	}
+	if ms.spilled != 0 {
+		t.Errorf("a failed store left %d payloads in the file", ms.spilled)
+	}
+	var r io.Reader = bytes.NewReader(nil)
+	if buf, sp, err := ms.Store(r, 0); err != nil || len(buf) != 0 || sp != nil {
+		t.Errorf("storing an empty payload: got %q, %v, %v", buf, sp, err)
+	}
+}
+
+func TestMessageStoreLivePayload(t *testing.T) {
+	ms := NewMessageStore(0)
+	defer ms.Close()
+	ms.segmentSize = 100
+	store := func(s string) *StoredPayload {
+		_, sp, err := ms.Store(strings.NewReader(s), len(s))
+		if err != nil {
+			t.Fatalf("error storing a payload: %v", err)
+		}
+		return sp
+	}
+	diskUse := func() int64 {
+		var total int64
+		for seg := range ms.segments {
+			fi, err := seg.f.Stat()
+			if err != nil {
+				t.Fatalf("error checking the size of a store's file: %v", err)
+			}
+			total += fi.Size()
+		}
+		return total
+	}
+	live := store("live")
+	first := live.segment.f.Name()
+	for i := 0; i < 1000; i++ {
+		store(strings.Repeat("x", 30)).Release()
+		if use := diskUse(); use > 2*ms.segmentSize {
+			t.Fatalf("store uses %d bytes of disk with a single live payload after %d payloads", use, i+1)
+		}
+	}
+	if got, err := ioutil.ReadAll(live.Reader()); err != nil || string(got) != "live" {
+		t.Errorf("wrong live payload: got %q, %v", got, err)
+	}
+	live.Release()
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("store's file %s still exists after all its payloads were released: %v", first, err)
+	}
+	if len(ms.segments) != 1 {
+		t.Errorf("store has %d files after all its payloads were released, want 1", len(ms.segments))
+	}
+}
+
+func TestInstancesSpilled(t *testing.T) {
+	var outputs [2]bytes.Buffer
+	cmds := make([]*exec.Cmd, 2)
+	for i, input := range []string{"Rb\nRb\n", "Safoo\nSabarbaz\n"} {
+		cmds[i] = exec.Command(testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+		cmds[i].Stdout = &outputs[i]
+	}
+	if _, err := RunInstances(context.Background(), Options{Commands: cmds, SpillThreshold: 1}); err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	if got, want := strings.Replace(outputs[0].String(), "\r\n", "\n", -1), "0 2\n1 3 foo\n1 6 barbaz\n"; got != want {
+		t.Errorf("wrong output from receiving instance: got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// A Termination describes how an instance has finished.
+type Termination struct {
+	// Exited is set if the instance's process has exited by itself with ExitCode.
+	Exited   bool
+	ExitCode int
+	// Signal is the name of the signal that has terminated the process (e.g. SIGSEGV), if any.
+	Signal     string
+	CoreDumped bool
+	// Killed is the reason for which parunner has killed the instance, if it did.
+	Killed string
+	// TimeLimit, MemoryLimit, MessageCountLimit and MessageSizeLimit describe the limit
+	// that the instance has exceeded, if any.
+	TimeLimit         string
+	MemoryLimit       string
+	MessageCountLimit string
+	MessageSizeLimit  string
+	// OutputLimit describes the output limit that the instance has exceeded, if any.
+	OutputLimit string
+	// UnexpectedOutput describes the output that the instance wasn't allowed to write
+	// (see ContestStdout), if any.
+	UnexpectedOutput string
+	// ProtocolError is the error in the instance's communication with parunner, if any.
+	ProtocolError string
+	// SandboxViolation is set if the instance was killed by the sandbox.
+	SandboxViolation bool
+	// ToolReport is the name of the tool (e.g. valgrind) that has reported an error in the
+	// instance, if any. ToolReportLine is the line of the report that we have recognized.
+	ToolReport     string
+	ToolReportLine string
+	// Error describes an error that has made the instance fail for a reason not covered by
+	// the other fields, e.g. a failure to write its output.
+	Error string
+}
+
+// Verdict returns a short description of the termination, in the style of programming
+// contest verdicts: OK, RE/SIGSEGV, RE/exit 3, TLE, MLE, and so on.
+func (t *Termination) Verdict() string {
+	switch {
+	case t.ProtocolError != "":
+		return "protocol error"
+	case t.TimeLimit != "":
+		return "TLE"
+	case t.MemoryLimit != "":
+		return "MLE"
+	case t.MessageCountLimit != "":
+		return "message count limit exceeded"
+	case t.MessageSizeLimit != "":
+		return "message size limit exceeded"
+	case t.OutputLimit != "":
+		return "output limit exceeded"
+	case t.UnexpectedOutput != "":
+		return "unexpected output"
+	case t.Killed != "":
+		return "killed"
+	case t.SandboxViolation:
+		return "sandbox violation"
+	case t.ToolReport != "":
+		return "RE/" + t.ToolReport
+	case t.Signal == "SIGXCPU":
+		return "TLE"
+	case t.Signal != "":
+		return "RE/" + t.Signal
+	case t.Exited && t.ExitCode != 0:
+		return fmt.Sprintf("RE/exit %d", t.ExitCode)
+	case t.Error != "":
+		return "error"
+	case t.Exited:
+		return "OK"
+	default:
+		return "unknown"
+	}
+}
+
+// Details returns a description of the termination's cause that complements Verdict, or
+// an empty string if there is nothing to add.
+func (t *Termination) Details() string {
+	switch {
+	case t.ProtocolError != "":
+		return t.ProtocolError
+	case t.TimeLimit != "":
+		return t.TimeLimit
+	case t.MemoryLimit != "":
+		return t.MemoryLimit
+	case t.MessageCountLimit != "":
+		return t.MessageCountLimit
+	case t.MessageSizeLimit != "":
+		return t.MessageSizeLimit
+	case t.OutputLimit != "":
+		return t.OutputLimit
+	case t.UnexpectedOutput != "":
+		return t.UnexpectedOutput
+	case t.Killed != "":
+		return t.Killed
+	case t.ToolReport != "":
+		return t.ToolReportLine
+	case t.Signal != "" && t.CoreDumped:
+		return "core dumped"
+	case t.Error != "":
+		return t.Error
+	default:
+		return ""
+	}
+}
+
+func (t *Termination) String() string {
+	if details := t.Details(); details != "" {
+		return fmt.Sprintf("%s (%s)", t.Verdict(), details)
+	}
+	return t.Verdict()
+}
+
+// processTermination describes the termination of a process whose Wait has returned err.
+func processTermination(err error) Termination {
+	switch err := err.(type) {
+	case nil:
+		return Termination{Exited: true}
+	case *exec.ExitError:
+		ws, ok := err.Sys().(syscall.WaitStatus)
+		if !ok {
+			return Termination{Exited: true, ExitCode: err.ExitCode()}
+		}
+		if ws.Signaled() {
+			return Termination{Signal: signalName(ws.Signal()), CoreDumped: ws.CoreDump()}
+		}
+		return Termination{Exited: true, ExitCode: ws.ExitStatus()}
+	case ErrSandboxViolation:
+		t := processTermination(err.Err)
+		t.SandboxViolation = true
+		return t
+	case ErrMemoryLimit:
+		t := processTermination(err.Err)
+		t.MemoryLimit = err.Error()
+		return t
+	case RemoteError:
+		if err.Termination != nil {
+			return *err.Termination
+		}
+	}
+	return Termination{Error: err.Error()}
+}
+
+// commTermination describes the termination of an instance caused by an error returned
+// from its communication with parunner.
+func commTermination(err error) Termination {
+	switch err.(type) {
+	case ErrTimeLimit:
+		return Termination{TimeLimit: err.Error()}
+	case ErrMessageCount:
+		return Termination{MessageCountLimit: err.Error()}
+	case ErrMessageSize:
+		return Termination{MessageSizeLimit: err.Error()}
+	}
+	if err == errNoResponse {
+		return Termination{Killed: err.Error()}
+	}
+	return Termination{ProtocolError: err.Error()}
+}
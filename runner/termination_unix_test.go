@@ -0,0 +1,109 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTermination(t *testing.T) {
+	testcases := []struct {
+		name    string
+		cmd     *exec.Cmd
+		input   string
+		opts    Options
+		verdict string
+	}{
+		{"ok", exec.Command(testerPath), "", Options{}, "OK"},
+		{"exit code", exec.Command(testerPath), "Q3\n", Options{}, "RE/exit 3"},
+		{"signal", exec.Command(testerPath), "X\n", Options{}, "RE/SIGABRT"},
+		{"CPU time limit", exec.Command("/bin/sh", "-c", "kill -XCPU $$"), "", Options{}, "TLE"},
+		{"time limit", exec.Command(testerPath), "T5000\nSafoo\nH\n", Options{TimeLimit: time.Second}, "TLE"},
+		{"time limit at exit", exec.Command(testerPath), "C\n", Options{TimeLimit: time.Millisecond}, "TLE"},
+		{"message count limit", exec.Command(testerPath), "Safoo\nSabar\nH\n", Options{MessageCountLimit: 1}, "message count limit exceeded"},
+		{"message size limit", exec.Command(testerPath), "Safoo\nSabar\nH\n", Options{MessageSizeLimit: 5}, "message size limit exceeded"},
+		{"protocol error", exec.Command("/bin/sh", "-c", "echo garbage garbage garbage >&4; sleep 10"), "", Options{}, "protocol error"},
+	}
+	for _, tc := range testcases {
+		tc.cmd.Stdin = strings.NewReader(tc.input)
+		tc.opts.Commands = []*exec.Cmd{tc.cmd}
+		is, err := RunInstances(context.Background(), tc.opts)
+		if _, ok := err.(ErrRemainingMessages); ok {
+			err = nil
+		}
+		if tc.verdict == "OK" {
+			if err != nil {
+				t.Errorf("test %s: unexpected error from RunInstances: %v", tc.name, err)
+			}
+		} else if ie, ok := err.(InstanceError); !ok || ie.Termination == nil {
+			t.Errorf("test %s: expected an InstanceError with a termination, got %v", tc.name, err)
+		}
+		if got := is[0].Termination.Verdict(); got != tc.verdict {
+			t.Errorf("test %s: wrong verdict: got=%q, want=%q (%+v)", tc.name, got, tc.verdict, is[0].Termination)
+		}
+	}
+}
+
+func TestTerminationKilled(t *testing.T) {
+	cmds := []*exec.Cmd{exec.Command(testerPath), exec.Command(testerPath)}
+	cmds[0].Stdin = strings.NewReader("Q3\n")
+	cmds[1].Stdin = strings.NewReader("H\n")
+	is, err := RunInstances(context.Background(), Options{Commands: cmds})
+	if ie, ok := err.(InstanceError); !ok || ie.ID != 0 {
+		t.Fatalf("expected an InstanceError of instance 0, got %v", err)
+	}
+	if got, want := is[1].Termination.String(), "killed (another instance has failed)"; got != want {
+		t.Errorf("wrong termination of the hanging instance: got=%q, want=%q", got, want)
+	}
+}
+
+func TestTerminationToolReport(t *testing.T) {
+	newCmd := func() *exec.Cmd {
+		return exec.Command("/bin/sh", "-c", "echo '==1==ERROR: AddressSanitizer: stack-buffer-overflow' >&2")
+	}
+	// Reports are only detected when asked for.
+	if _, err := RunInstances(context.Background(), Options{Commands: []*exec.Cmd{newCmd()}}); err != nil {
+		t.Errorf("unexpected error without report detection: %v", err)
+	}
+	is, err := RunInstances(context.Background(), Options{Commands: []*exec.Cmd{newCmd()}, Instances: []InstanceOptions{{DetectToolReports: true}}})
+	ie, ok := err.(InstanceError)
+	if !ok {
+		t.Fatalf("expected an InstanceError, got %v", err)
+	}
+	if _, ok := ie.Err.(ErrToolReport); !ok {
+		t.Errorf("expected ErrToolReport, got %v", ie.Err)
+	}
+	if got, want := is[0].Termination.Verdict(), "RE/AddressSanitizer"; got != want {
+		t.Errorf("wrong verdict: got=%q, want=%q", got, want)
+	}
+}
+
+func TestInstancesWrapper(t *testing.T) {
+	var outputs [2]bytes.Buffer
+	cmds := make([]*exec.Cmd, 2)
+	for i, input := range []string{"C\nRb\n", "Safoo\n"} {
+		// env passes the file descriptors on, like a well-behaved wrapper should.
+		cmds[i] = exec.Command("env", "PARUNNER_WRAPPED=1", testerPath)
+		cmds[i].Stdin = strings.NewReader(input)
+		cmds[i].Stdout = &outputs[i]
+	}
+	// The CPU time measured by the OS would include the wrapper's processes.
+	opts := Options{Commands: cmds, Instances: []InstanceOptions{{TimeFromRequests: true}, {TimeFromRequests: true}}}
+	is, err := RunInstances(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("unexpected error from RunInstances: %v", err)
+	}
+	for i, want := range []string{"0 2\n1 3 foo\n", "1 2\n"} {
+		if got := outputs[i].String(); got != want {
+			t.Errorf("wrong output from instance %d: got=%q, want=%q", i, got, want)
+		}
+	}
+	if !is[0].TimeFromRequests || is[0].TimeRunning == 0 {
+		t.Errorf("the time of a wrapped instance wasn't taken from its requests: %v", is[0].TimeRunning)
+	}
+}
@@ -0,0 +1,11 @@
+package runner
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Processes are not terminated by signals on Windows, so this is never really used.
+func signalName(sig syscall.Signal) string {
+	return fmt.Sprintf("signal %d", int(sig))
+}
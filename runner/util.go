@@ -0,0 +1,189 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxContestOutputPrefix limits the number of first bytes of the instances' outputs shown
+// in an ErrContestOutput.
+const maxContestOutputPrefix = 32
+
+// ErrContestOutput is returned when an instance writes to a ContestStdout although it
+// may not write output.
+type ErrContestOutput struct {
+	ID int
+	// Output are the first bytes that the instance has tried to write.
+	Output []byte
+	// Writer is the ID of the instance that may write output. WriterOutput are the first
+	// bytes that it has written, if it has written any.
+	Writer       int
+	WriterOutput []byte
+	// Designated is set if Writer was designated in advance, instead of being the first
+	// instance to write output.
+	Designated bool
+}
+
+func (err ErrContestOutput) Error() string {
+	if err.Designated {
+		return fmt.Sprintf("instance %d has tried to write %q, but only instance %d may write output", err.ID, err.Output, err.Writer)
+	}
+	return fmt.Sprintf("instance %d has tried to write %q, but instance %d has already written %q", err.ID, err.Output, err.Writer, err.WriterOutput)
+}
+
+// ContestStdout lets at most one instance write output, like the contest systems do.
+type ContestStdout struct {
+	Output io.Writer
+	// If SingleNode is set, only the instance with ID Node may write output. Otherwise,
+	// the first instance that writes output is the only one that may do so.
+	SingleNode bool
+	Node       int
+
+	chosenInstance int
+	chooseInstance sync.Once
+
+	mu sync.Mutex
+	// prefix are the first bytes written by the chosen instance.
+	prefix []byte
+}
+
+type contestStdoutWriter struct {
+	cs *ContestStdout
+	id int
+}
+
+func truncateOutput(buf []byte) []byte {
+	if len(buf) > maxContestOutputPrefix {
+		buf = buf[:maxContestOutputPrefix]
+	}
+	return append([]byte(nil), buf...)
+}
+
+func (w *contestStdoutWriter) Write(buf []byte) (int, error) {
+	w.cs.chooseInstance.Do(func() {
+		w.cs.chosenInstance = w.id
+		if w.cs.SingleNode {
+			w.cs.chosenInstance = w.cs.Node
+		}
+	})
+	w.cs.mu.Lock()
+	defer w.cs.mu.Unlock()
+	if w.cs.chosenInstance != w.id {
+		return 0, ErrContestOutput{
+			ID:           w.id,
+			Output:       truncateOutput(buf),
+			Writer:       w.cs.chosenInstance,
+			WriterOutput: append([]byte(nil), w.cs.prefix...),
+			Designated:   w.cs.SingleNode,
+		}
+	}
+	if len(w.cs.prefix) < maxContestOutputPrefix {
+		w.cs.prefix = truncateOutput(append(w.cs.prefix, buf...))
+	}
+	return w.cs.Output.Write(buf)
+}
+
+func (w *contestStdoutWriter) Close() error {
+	return nil
+}
+
+func (cs *ContestStdout) NewWriter(id int) OutputSink {
+	return &contestStdoutWriter{cs: cs, id: id}
+}
+
+func TagStream(tag string, w io.Writer, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if _, err := fmt.Fprintf(w, "%s%s\n", tag, sc.Text()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+type WriterError error
+
+type wrappedWriter struct {
+	io.Writer
+}
+
+func (w wrappedWriter) Write(buf []byte) (int, error) {
+	n, err := w.Writer.Write(buf)
+	if err != nil {
+		err = WriterError(err)
+	}
+	return n, err
+}
+
+func WrapWriter(w io.Writer) io.Writer {
+	return wrappedWriter{w}
+}
+
+// maxTailLineLength limits the length of a line kept by a TailBuffer, so that an instance
+// that writes a lot of output without newlines can't make us use a lot of memory.
+const maxTailLineLength = 1024
+
+// TailBuffer is a writer that remembers the last lines written to it.
+type TailBuffer struct {
+	n int
+
+	mu    sync.Mutex
+	lines []string
+	line  []byte
+	// truncated is set if the current line was longer than maxTailLineLength.
+	truncated bool
+}
+
+// NewTailBuffer creates a TailBuffer that remembers the last n lines.
+func NewTailBuffer(n int) *TailBuffer {
+	return &TailBuffer{n: n}
+}
+
+func (tb *TailBuffer) Write(buf []byte) (int, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, b := range buf {
+		if b == '\n' {
+			tb.endLine()
+			continue
+		}
+		if len(tb.line) < maxTailLineLength {
+			tb.line = append(tb.line, b)
+		} else {
+			tb.truncated = true
+		}
+	}
+	return len(buf), nil
+}
+
+func (tb *TailBuffer) currentLine() string {
+	if tb.truncated {
+		return string(tb.line) + "..."
+	}
+	return string(tb.line)
+}
+
+func (tb *TailBuffer) endLine() {
+	tb.lines = append(tb.lines, tb.currentLine())
+	if len(tb.lines) > tb.n {
+		tb.lines = tb.lines[len(tb.lines)-tb.n:]
+	}
+	tb.line = tb.line[:0]
+	tb.truncated = false
+}
+
+// Lines returns the last lines written, including an unterminated last line.
+func (tb *TailBuffer) Lines() []string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	lines := append([]string(nil), tb.lines...)
+	if len(tb.line) > 0 {
+		lines = append(lines, tb.currentLine())
+		if len(lines) > tb.n {
+			lines = lines[len(lines)-tb.n:]
+		}
+	}
+	return lines
+}
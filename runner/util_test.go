@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestContestStdout(t *testing.T) {
+	var buf bytes.Buffer
+	cs := &ContestStdout{Output: &buf}
+
+	const N = 10
+	var wg sync.WaitGroup
+	token := make(chan bool, 1)
+	token <- true
+	expectedOutput := make(chan string, 1)
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := fmt.Sprintf("%d\nfoobarbazblah", i)
+			w := cs.NewWriter(i)
+			_, err := io.Copy(w, strings.NewReader(s))
+			if err != nil {
+				return
+			}
+			select {
+			case <-token:
+			default:
+				t.Errorf("instance %d was also able to write", i)
+				return
+			}
+			expectedOutput <- s
+		}(i)
+	}
+	want := <-expectedOutput
+	got := buf.String()
+	if got != want {
+		t.Errorf("wrong output of ContestStdout: got=%q, want=%q", got, want)
+	}
+}
+
+func TestContestStdoutErrors(t *testing.T) {
+	var buf bytes.Buffer
+	cs := &ContestStdout{Output: &buf}
+	cs.NewWriter(1).Write([]byte("foo"))
+	cs.NewWriter(1).Write([]byte(strings.Repeat("x", 100)))
+	_, err := cs.NewWriter(2).Write([]byte("bar"))
+	want := ErrContestOutput{ID: 2, Output: []byte("bar"), Writer: 1, WriterOutput: []byte("foo" + strings.Repeat("x", maxContestOutputPrefix-3))}
+	if fmt.Sprint(err) != fmt.Sprint(want) {
+		t.Errorf("wrong error from the second writer: got=%v, want=%v", err, want)
+	}
+
+	buf.Reset()
+	cs = &ContestStdout{Output: &buf, SingleNode: true, Node: 3}
+	_, err = cs.NewWriter(2).Write([]byte("bar"))
+	want = ErrContestOutput{ID: 2, Output: []byte("bar"), Writer: 3, Designated: true}
+	if fmt.Sprint(err) != fmt.Sprint(want) {
+		t.Errorf("wrong error from a writer that isn't designated: got=%v, want=%v", err, want)
+	}
+	if _, err := cs.NewWriter(3).Write([]byte("foo")); err != nil {
+		t.Errorf("unexpected error from the designated writer: %v", err)
+	}
+	if got := buf.String(); got != "foo" {
+		t.Errorf("wrong output of ContestStdout: got=%q, want=%q", got, "foo")
+	}
+}
+
+func TestTagStream(t *testing.T) {
+	for _, tc := range []struct {
+		input  string
+		output string
+	}{
+		{"foo\n", "PREFIXfoo\n"},
+		{"foo", "PREFIXfoo\n"},
+		{"foo\n\nbar", "PREFIXfoo\nPREFIX\nPREFIXbar\n"},
+	} {
+		var buf bytes.Buffer
+		if err := TagStream("PREFIX", &buf, strings.NewReader(tc.input)); err != nil {
+			t.Errorf("TagStream failed: %v", err)
+			continue
+		}
+		if got := buf.String(); got != tc.output {
+			t.Errorf("TagStream returned invalid output: got=%q, want=%q", got, tc.output)
+		}
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	long := strings.Repeat("x", maxTailLineLength+10)
+	for _, tc := range []struct {
+		writes []string
+		lines  []string
+	}{
+		{nil, nil},
+		{[]string{"foo\n"}, []string{"foo"}},
+		{[]string{"foo\nbar"}, []string{"foo", "bar"}},
+		{[]string{"a\nb\nc\nd\n"}, []string{"b", "c", "d"}},
+		{[]string{"a\nb", "\nc\nd", "e"}, []string{"b", "c", "de"}},
+		{[]string{"\n\n"}, []string{"", ""}},
+		{[]string{long, "\nfoo\n"}, []string{long[:maxTailLineLength] + "...", "foo"}},
+	} {
+		tb := NewTailBuffer(3)
+		for _, w := range tc.writes {
+			if n, err := tb.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("TailBuffer.Write(%q) = %d, %v", w, n, err)
+			}
+		}
+		if got := tb.Lines(); fmt.Sprint(got) != fmt.Sprint(tc.lines) || len(got) != len(tc.lines) {
+			t.Errorf("wrong lines after writing %q: got=%q, want=%q", tc.writes, got, tc.lines)
+		}
+	}
+}
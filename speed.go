@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var timeScale = flag.Float64("time_scale", 1, "Factor that all the CPU times measured are multiplied by, e.g. to match the speed of the judge's machines")
+var speeds = flag.String("speed", "", "Comma-separated ID:factor pairs giving the speeds of individual instances relative to the others, e.g. 3:0.5 to simulate instance 3 running on a machine twice as slow")
+
+// parseSpeeds parses the -speed list for n instances into a map from instance IDs to
+// their speed factors.
+func parseSpeeds(s string, n int) (map[int]float64, error) {
+	result := make(map[int]float64)
+	if s == "" {
+		return result, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		i := strings.Index(part, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid instance speed %q: expected ID:factor", part)
+		}
+		id, err := strconv.Atoi(part[:i])
+		if err != nil || id < 0 || id >= n {
+			return nil, fmt.Errorf("invalid instance ID in instance speed %q", part)
+		}
+		factor, err := strconv.ParseFloat(part[i+1:], 64)
+		if err != nil || !(factor > 0) {
+			return nil, fmt.Errorf("invalid factor in instance speed %q", part)
+		}
+		result[id] = factor
+	}
+	return result, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSpeeds(t *testing.T) {
+	got, err := parseSpeeds("3:0.5,0:2", 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[int]float64{0: 2, 3: 0.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong speeds: got=%v, want=%v", got, want)
+	}
+	for _, s := range []string{"3", "4:1", "-1:1", "a:1", "1:0", "1:-2", "1:x", "1:NaN"} {
+		if _, err := parseSpeeds(s, 4); err == nil {
+			t.Errorf("parseSpeeds(%q): expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// median returns the median of ds, which must not be empty.
+func median(ds []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+// stddev returns the sample standard deviation of ds, or 0 if there are fewer than two
+// elements in ds.
+func stddev(ds []time.Duration) time.Duration {
+	if len(ds) < 2 {
+		return 0
+	}
+	var mean float64
+	for _, d := range ds {
+		mean += float64(d)
+	}
+	mean /= float64(len(ds))
+	var sum float64
+	for _, d := range ds {
+		sum += (float64(d) - mean) * (float64(d) - mean)
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(ds)-1)))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMedian(t *testing.T) {
+	testcases := []struct {
+		ds   []time.Duration
+		want time.Duration
+	}{
+		{[]time.Duration{5}, 5},
+		{[]time.Duration{3, 1, 2}, 2},
+		{[]time.Duration{4, 1, 2, 100}, 3},
+	}
+	for _, tc := range testcases {
+		if got := median(tc.ds); got != tc.want {
+			t.Errorf("median(%v): got=%v, want=%v", tc.ds, got, tc.want)
+		}
+	}
+}
+
+func TestStddev(t *testing.T) {
+	testcases := []struct {
+		ds   []time.Duration
+		want time.Duration
+	}{
+		{[]time.Duration{5}, 0},
+		{[]time.Duration{7, 7, 7}, 0},
+		{[]time.Duration{2, 4, 4, 4, 5, 5, 7, 9}, 2},
+	}
+	for _, tc := range testcases {
+		if got := stddev(tc.ds); got != tc.want {
+			t.Errorf("stddev(%v): got=%v, want=%v", tc.ds, got, tc.want)
+		}
+	}
+}
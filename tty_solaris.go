@@ -0,0 +1,17 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// The syscall package doesn't provide ioctl on Solaris, so the foreground process group of
+// the terminal isn't restored after debugging.
+
+func foregroundGroup(tty *os.File) (int32, error) {
+	return 0, errors.New("getting the foreground process group is not supported on Solaris")
+}
+
+func setForegroundGroup(tty *os.File, pgrp int32) error {
+	return errors.New("setting the foreground process group is not supported on Solaris")
+}
@@ -0,0 +1,26 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// foregroundGroup returns the process group in the foreground of the terminal tty.
+func foregroundGroup(tty *os.File) (int32, error) {
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return 0, errno
+	}
+	return pgrp, nil
+}
+
+// setForegroundGroup places the process group pgrp in the foreground of the terminal tty.
+func setForegroundGroup(tty *os.File, pgrp int32) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+// Package wire implements the framing that zeus_local.c uses to talk to parunner.
+//
+// A node starts by reading a Header from its input stream. It then writes a
+// sequence of requests (sends and receives) to its output stream. Every receive
+// request is answered with a response on the input stream. All integers are
+// 32 bits wide and little endian.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	Magic         = 1736434764
+	ResponseMagic = Magic + 1
+	// SharedResponseMagic starts a response whose payload was placed in shared memory.
+	SharedResponseMagic = Magic + 2
+
+	OpSend = 3
+	OpRecv = 4
+	// OpSendShared is a send whose payload was placed in shared memory.
+	OpSendShared = 5
+
+	// MaxMessageSize is the largest message zeus_local.c is willing to send.
+	MaxMessageSize = 8 * 1024 * 1024
+)
+
+// payloadChunk is the size of the largest buffer that is allocated for a payload
+// before any of its bytes are actually read. Longer payloads are read incrementally,
+// so that a frame that claims to be huge, but is truncated, doesn't cause a huge allocation.
+const payloadChunk = 64 * 1024
+
+// A Header is sent to a node at the very beginning of its input stream.
+type Header struct {
+	NodeCount int
+	NodeID    int
+}
+
+// A Request is a single operation issued by a node.
+type Request struct {
+	Op byte
+	// Peer is the target of a send or the source of a receive. -1 means any source.
+	Peer int
+	// Time is the CPU time the node has used before issuing the request. It has
+	// millisecond resolution on the wire.
+	Time time.Duration
+	// Length is the length of the message to send. It is only set for OpSend
+	// and OpSendShared.
+	Length int
+	// Payload is the message to send. It is only set for OpSend, and only
+	// by ReadRequest.
+	Payload []byte
+}
+
+// A Response carries a message to a node that has issued a receive request.
+type Response struct {
+	Source int
+	// Shared is set if the payload was placed in shared memory. Payload is then
+	// empty and Length holds the payload's length.
+	Shared  bool
+	Length  int
+	Payload []byte
+}
+
+// A FormatError reports a frame that is well-formed on the byte level, but contains
+// invalid values.
+type FormatError struct {
+	Msg string
+}
+
+func (e *FormatError) Error() string {
+	return "wire: " + e.Msg
+}
+
+func formatError(format string, args ...interface{}) error {
+	return &FormatError{Msg: fmt.Sprintf(format, args...)}
+}
+
+type rawHeader struct {
+	Magic     uint32
+	NodeCount int32
+	NodeID    int32
+}
+
+type rawResponse struct {
+	Magic    uint32
+	SourceID int32
+	Length   int32
+}
+
+type rawSend struct {
+	TargetID int32
+	Time     int32 // milliseconds
+	Length   int32
+}
+
+type rawRecv struct {
+	SourceID int32
+	Time     int32 // milliseconds
+}
+
+// A Decoder reads frames from a stream. Limits that are zero are not enforced.
+type Decoder struct {
+	r io.Reader
+
+	// MaxMessageSize is the maximal length of a payload.
+	MaxMessageSize int
+	// NodeLimit is the exclusive upper bound on node IDs.
+	NodeLimit int
+}
+
+// NewDecoder returns a Decoder that reads from r and accepts messages of at most MaxMessageSize bytes.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, MaxMessageSize: MaxMessageSize}
+}
+
+// readFrame reads a fixed-size part of a frame. An EOF is reported as io.ErrUnexpectedEOF
+// unless atStart is true and no bytes were read.
+func (d *Decoder) readFrame(v interface{}, atStart bool) error {
+	err := binary.Read(d.r, binary.LittleEndian, v)
+	if err == io.EOF && !atStart {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *Decoder) checkNode(id int32, what string) error {
+	if id < 0 || (d.NodeLimit > 0 && int(id) >= d.NodeLimit) {
+		return formatError("invalid %s: %d", what, id)
+	}
+	return nil
+}
+
+func (d *Decoder) checkLength(length int32) error {
+	if length < 0 || (d.MaxMessageSize > 0 && int(length) > d.MaxMessageSize) {
+		return formatError("invalid message size: %d", length)
+	}
+	return nil
+}
+
+func (d *Decoder) readPayload(length int) ([]byte, error) {
+	if length <= payloadChunk {
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(d.r, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return buf, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(payloadChunk)
+	n, err := io.CopyN(&buf, d.r, int64(length))
+	if n < int64(length) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadHeader reads the header that starts a node's input stream.
+func (d *Decoder) ReadHeader() (*Header, error) {
+	var h rawHeader
+	if err := d.readFrame(&h, true); err != nil {
+		return nil, err
+	}
+	if h.Magic != Magic {
+		return nil, formatError("invalid header magic 0x%x", h.Magic)
+	}
+	if h.NodeCount < 1 {
+		return nil, formatError("invalid node count: %d", h.NodeCount)
+	}
+	if h.NodeID < 0 || h.NodeID >= h.NodeCount {
+		return nil, formatError("invalid node ID %d for %d nodes", h.NodeID, h.NodeCount)
+	}
+	return &Header{NodeCount: int(h.NodeCount), NodeID: int(h.NodeID)}, nil
+}
+
+// ReadRequest reads a single request. It returns io.EOF if the stream ends cleanly
+// between requests and io.ErrUnexpectedEOF if it ends in the middle of one.
+func (d *Decoder) ReadRequest() (*Request, error) {
+	req, err := d.ReadRequestHeader()
+	if err != nil {
+		return nil, err
+	}
+	if req.Op == OpSend {
+		if req.Payload, err = d.readPayload(req.Length); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// ReadRequestHeader reads a single request, but leaves the payload of a send request
+// in the stream. The caller must consume it with Payload before reading the next request.
+func (d *Decoder) ReadRequestHeader() (*Request, error) {
+	var op [1]byte
+	if _, err := io.ReadFull(d.r, op[:]); err != nil {
+		return nil, err
+	}
+	switch op[0] {
+	case OpSend, OpSendShared:
+		var sh rawSend
+		if err := d.readFrame(&sh, false); err != nil {
+			return nil, err
+		}
+		if err := d.checkLength(sh.Length); err != nil {
+			return nil, err
+		}
+		if err := d.checkNode(sh.TargetID, "target node in a send request"); err != nil {
+			return nil, err
+		}
+		return &Request{Op: op[0], Peer: int(sh.TargetID), Time: fromMillis(sh.Time), Length: int(sh.Length)}, nil
+	case OpRecv:
+		var rh rawRecv
+		if err := d.readFrame(&rh, false); err != nil {
+			return nil, err
+		}
+		if rh.SourceID != -1 {
+			if err := d.checkNode(rh.SourceID, "source node in a receive request"); err != nil {
+				return nil, err
+			}
+		}
+		return &Request{Op: OpRecv, Peer: int(rh.SourceID), Time: fromMillis(rh.Time)}, nil
+	default:
+		return nil, formatError("invalid operation type 0x%x", op[0])
+	}
+}
+
+// Payload returns a reader for the length bytes of payload that follow a request header.
+// The reader returns io.ErrUnexpectedEOF if the stream ends before the payload does.
+func (d *Decoder) Payload(length int) io.Reader {
+	return &payloadReader{r: d.r, remaining: int64(length)}
+}
+
+type payloadReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (pr *payloadReader) Read(buf []byte) (int, error) {
+	if pr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > pr.remaining {
+		buf = buf[:pr.remaining]
+	}
+	n, err := pr.r.Read(buf)
+	pr.remaining -= int64(n)
+	if err == io.EOF && pr.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// ReadResponse reads a response to a receive request.
+func (d *Decoder) ReadResponse() (*Response, error) {
+	var rr rawResponse
+	if err := d.readFrame(&rr, true); err != nil {
+		return nil, err
+	}
+	if rr.Magic != ResponseMagic && rr.Magic != SharedResponseMagic {
+		return nil, formatError("invalid response magic 0x%x", rr.Magic)
+	}
+	if err := d.checkNode(rr.SourceID, "source node in a response"); err != nil {
+		return nil, err
+	}
+	if err := d.checkLength(rr.Length); err != nil {
+		return nil, err
+	}
+	if rr.Magic == SharedResponseMagic {
+		return &Response{Source: int(rr.SourceID), Shared: true, Length: int(rr.Length)}, nil
+	}
+	payload, err := d.readPayload(int(rr.Length))
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Source: int(rr.SourceID), Length: len(payload), Payload: payload}, nil
+}
+
+// An Encoder writes frames to a stream.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) writePayload(payload []byte) error {
+	if n, err := e.w.Write(payload); n < len(payload) {
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return err
+	}
+	return nil
+}
+
+// WriteHeader writes the header that starts a node's input stream.
+func (e *Encoder) WriteHeader(h *Header) error {
+	return binary.Write(e.w, binary.LittleEndian, &rawHeader{
+		Magic:     Magic,
+		NodeCount: int32(h.NodeCount),
+		NodeID:    int32(h.NodeID),
+	})
+}
+
+// WriteRequest writes a single request.
+func (e *Encoder) WriteRequest(req *Request) error {
+	var frame interface{}
+	switch req.Op {
+	case OpSend:
+		frame = &rawSend{TargetID: int32(req.Peer), Time: toMillis(req.Time), Length: int32(len(req.Payload))}
+	case OpSendShared:
+		frame = &rawSend{TargetID: int32(req.Peer), Time: toMillis(req.Time), Length: int32(req.Length)}
+	case OpRecv:
+		frame = &rawRecv{SourceID: int32(req.Peer), Time: toMillis(req.Time)}
+	default:
+		return formatError("invalid operation type 0x%x", req.Op)
+	}
+	if _, err := e.w.Write([]byte{req.Op}); err != nil {
+		return err
+	}
+	if err := binary.Write(e.w, binary.LittleEndian, frame); err != nil {
+		return err
+	}
+	if req.Op == OpSend {
+		return e.writePayload(req.Payload)
+	}
+	return nil
+}
+
+// WriteResponse writes a response to a receive request.
+func (e *Encoder) WriteResponse(resp *Response) error {
+	if resp.Shared {
+		return e.writeResponseHeader(SharedResponseMagic, resp.Source, resp.Length)
+	}
+	if err := e.writeResponseHeader(ResponseMagic, resp.Source, len(resp.Payload)); err != nil {
+		return err
+	}
+	return e.writePayload(resp.Payload)
+}
+
+// WriteResponseFrom writes a response to a receive request, copying the length bytes
+// of its payload from r.
+func (e *Encoder) WriteResponseFrom(source int, length int, r io.Reader) error {
+	if err := e.writeResponseHeader(ResponseMagic, source, length); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(e.w, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *Encoder) writeResponseHeader(magic uint32, source int, length int) error {
+	rr := rawResponse{
+		Magic:    magic,
+		SourceID: int32(source),
+		Length:   int32(length),
+	}
+	return binary.Write(e.w, binary.LittleEndian, &rr)
+}
+
+func fromMillis(ms int32) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+func toMillis(d time.Duration) int32 {
+	return int32(d / time.Millisecond)
+}
@@ -0,0 +1,208 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func frame(op byte, fields ...int32) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(op)
+	binary.Write(&buf, binary.LittleEndian, fields)
+	return buf.Bytes()
+}
+
+func TestReadRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   []byte
+		want    *Request
+		wantErr bool
+	}{
+		{"send", append(frame(OpSend, 2, 15, 3), "foo"...), &Request{Op: OpSend, Peer: 2, Time: 15 * time.Millisecond, Length: 3, Payload: []byte("foo")}, false},
+		{"empty send", frame(OpSend, 2, 0, 0), &Request{Op: OpSend, Peer: 2, Payload: []byte{}}, false},
+		{"recv", frame(OpRecv, 3, 7), &Request{Op: OpRecv, Peer: 3, Time: 7 * time.Millisecond}, false},
+		{"recvany", frame(OpRecv, -1, 7), &Request{Op: OpRecv, Peer: -1, Time: 7 * time.Millisecond}, false},
+		{"shared send", append(frame(OpSendShared, 2, 15, 3), "foo"...), &Request{Op: OpSendShared, Peer: 2, Time: 15 * time.Millisecond, Length: 3}, false},
+		{"invalid op", frame(6, 0, 0), nil, true},
+		{"negative size", frame(OpSend, 2, 0, -1), nil, true},
+		{"huge size", frame(OpSend, 2, 0, MaxMessageSize+1), nil, true},
+		{"negative target", frame(OpSend, -1, 0, 0), nil, true},
+		{"target out of range", frame(OpSend, 10, 0, 0), nil, true},
+		{"source out of range", frame(OpRecv, -2, 0), nil, true},
+		{"truncated header", frame(OpSend, 2)[:6], nil, true},
+		{"truncated payload", append(frame(OpSend, 2, 0, MaxMessageSize), "foo"...), nil, true},
+	} {
+		d := NewDecoder(bytes.NewReader(tc.input))
+		d.NodeLimit = 10
+		got, err := d.ReadRequest()
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("test %s: expected an error, got request %+v", tc.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("test %s: got=%+v, want=%+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReadRequestEOF(t *testing.T) {
+	if _, err := NewDecoder(bytes.NewReader(nil)).ReadRequest(); err != io.EOF {
+		t.Errorf("reading from an empty stream: got error %v, want %v", err, io.EOF)
+	}
+	if _, err := NewDecoder(bytes.NewReader([]byte{OpRecv})).ReadRequest(); err != io.ErrUnexpectedEOF {
+		t.Errorf("reading a truncated request: got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func FuzzReadRequest(f *testing.F) {
+	f.Add(append(frame(OpSend, 2, 15, 3), "foo"...))
+	f.Add(frame(OpRecv, -1, 7))
+	f.Add(frame(OpSend, 2, 0, MaxMessageSize))
+	f.Add(frame(OpSend, 2, 0, -5))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(bytes.NewReader(data))
+		d.NodeLimit = 100
+		for {
+			req, err := d.ReadRequest()
+			if err != nil {
+				return
+			}
+			if req.Peer < -1 || req.Peer >= 100 || (req.Op == OpSend && req.Peer == -1) {
+				t.Fatalf("decoder accepted a request with invalid peer: %+v", req)
+			}
+			if len(req.Payload) > len(data) {
+				t.Fatalf("decoder returned a payload of %d bytes from %d bytes of input", len(req.Payload), len(data))
+			}
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(true, 3, int32(15), []byte("foo"))
+	f.Add(false, -1, int32(0), []byte(nil))
+	f.Fuzz(func(t *testing.T, send bool, peer int, ms int32, payload []byte) {
+		req := &Request{Op: OpRecv, Peer: peer, Time: time.Duration(ms) * time.Millisecond}
+		if send {
+			req.Op = OpSend
+			req.Length = len(payload)
+			req.Payload = payload
+		}
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).WriteRequest(req); err != nil {
+			t.Fatalf("WriteRequest(%+v) failed: %v", req, err)
+		}
+		if int(int32(peer)) != peer {
+			t.Skip("peer doesn't fit on the wire")
+		}
+		got, err := NewDecoder(&buf).ReadRequest()
+		if valid := peer >= 0 || (!send && peer == -1); !valid {
+			if err == nil {
+				t.Fatalf("decoder accepted an invalid request: sent %+v, got %+v", req, got)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("ReadRequest failed for a valid request %+v: %v", req, err)
+		}
+		if send && req.Payload == nil {
+			req.Payload = []byte{}
+		}
+		if !reflect.DeepEqual(got, req) {
+			t.Fatalf("round trip mismatch: sent %+v, got %+v", req, got)
+		}
+
+		resp := &Response{Source: peer, Payload: payload}
+		buf.Reset()
+		if err := NewEncoder(&buf).WriteResponse(resp); err != nil {
+			t.Fatalf("WriteResponse(%+v) failed: %v", resp, err)
+		}
+		gotResp, err := NewDecoder(&buf).ReadResponse()
+		if peer < 0 {
+			if err == nil {
+				t.Fatalf("decoder accepted a response from an invalid source: %+v", gotResp)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("ReadResponse failed for %+v: %v", resp, err)
+		}
+		if gotResp.Source != resp.Source || !bytes.Equal(gotResp.Payload, resp.Payload) {
+			t.Fatalf("round trip mismatch: sent %+v, got %+v", resp, gotResp)
+		}
+	})
+}
+
+func TestStreamedPayload(t *testing.T) {
+	input := append(frame(OpSend, 2, 15, 6), "foobarRest"...)
+	d := NewDecoder(bytes.NewReader(input))
+	req, err := d.ReadRequestHeader()
+	if err != nil {
+		t.Fatalf("ReadRequestHeader failed: %v", err)
+	}
+	if req.Length != 6 || req.Payload != nil {
+		t.Fatalf("ReadRequestHeader returned %+v, want a header with length 6 and no payload", req)
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).WriteResponseFrom(1, req.Length, d.Payload(req.Length)); err != nil {
+		t.Fatalf("WriteResponseFrom failed: %v", err)
+	}
+	resp, err := NewDecoder(&buf).ReadResponse()
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if got, want := string(resp.Payload), "foobar"; got != want {
+		t.Errorf("streamed payload mismatch: got=%q, want=%q", got, want)
+	}
+
+	d = NewDecoder(bytes.NewReader(append(frame(OpSend, 2, 15, 6), "foo"...)))
+	if req, err = d.ReadRequestHeader(); err != nil {
+		t.Fatalf("ReadRequestHeader failed: %v", err)
+	}
+	if _, err := io.Copy(&buf, d.Payload(req.Length)); err != io.ErrUnexpectedEOF {
+		t.Errorf("reading a truncated payload: got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestSharedResponse(t *testing.T) {
+	var buf bytes.Buffer
+	want := &Response{Source: 3, Shared: true, Length: 1234}
+	if err := NewEncoder(&buf).WriteResponse(want); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+	if buf.Len() != 12 {
+		t.Errorf("a shared response took %d bytes on the wire, want 12", buf.Len())
+	}
+	got, err := NewDecoder(&buf).ReadResponse()
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shared response round trip mismatch: got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	want := &Header{NodeCount: 20, NodeID: 5}
+	if err := NewEncoder(&buf).WriteHeader(want); err != nil {
+		t.Fatalf("WriteHeader failed: %v", err)
+	}
+	got, err := NewDecoder(&buf).ReadHeader()
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("header round trip mismatch: got=%+v, want=%+v", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"os/exec"
+	"strings"
+)
+
+var wrapper = flag.String("wrapper", "", "Command (with space-separated arguments) to run the instances under, e.g. \"valgrind -q\"; the binary is appended to it")
+var wrapperFor = flag.Int("wrapper_for", -1, "ID of the only instance to run under -wrapper; -1 means all instances")
+var sanitizers = flag.Bool("sanitizers", false, "Make the instances fail when a sanitizer that they are built with (e.g. with -fsanitize=address) reports an error")
+
+const wrapperUsage = `Wrappers:
+  The wrapper receives the binary as its last argument and must pass file descriptors 3 and 4 (and
+  5 with -shm) on to it unchanged, as they are used for communication. The CPU time of a wrapped
+  instance is the one reported by the instance in its last request, so it excludes the time used by
+  the wrapper's own processes, but includes the slowdown of instrumentation. The time the instance
+  uses after its last request isn't counted. An instance that makes no requests is given the
+  measured CPU time, which includes the wrapper's. Error reports of valgrind and of the sanitizers
+  found in the standard error of the wrapped instances, or of all the instances with -sanitizers,
+  make the instances fail.
+`
+
+// wrapped returns true if the instance with the given ID is run under -wrapper.
+func wrapped(id int) bool {
+	return *wrapper != "" && (*wrapperFor == -1 || *wrapperFor == id)
+}
+
+// wrapperCommand returns a command that runs binary under -wrapper.
+func wrapperCommand(binary string) *exec.Cmd {
+	args := append(strings.Fields(*wrapper), binary)
+	return exec.Command(args[0], args[1:]...)
+}